@@ -1,7 +1,11 @@
 package actions
 
 import (
+	"context"
+	"crypto/tls"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
+	"net"
 	"net/smtp"
 	"strings"
 )
@@ -9,28 +13,86 @@ import (
 type EMailAction struct {
 	Address  string
 	User     string
-	Password string
+	Password string `json:"-"`
 	From     string
 	To       string
 	Message  string
 	Host     string
 }
 
-func (ea *EMailAction) Initialize(config map[string]interface{}) *EMailAction {
-	ea.Address = config["address"].(string)
-	ea.User = config["user"].(string)
-	ea.Password = config["password"].(string)
-	ea.From = config["from"].(string)
-	ea.To = config["to"].(string)
-	ea.Message = config["message"].(string)
-	ea.Host = config["host"].(string)
+func (ea *EMailAction) Initialize(config *config.Section) *EMailAction {
+	ea.Address = config.String("address")
+	ea.User = config.String("user")
+	ea.Password = config.String("password")
+	ea.From = config.String("from")
+	ea.To = config.String("to")
+	ea.Message = config.String("message")
+	// the host name used for TLS and authentication defaults to the host of the address
+	host, _, err := net.SplitHostPort(ea.Address)
+	if err != nil {
+		host = ea.Address
+	}
+	ea.Host = config.OptionalString("host", host)
 	return ea
 }
 
-func (ea *EMailAction) Run(eventManager interfaces.EventManager, id string) {
-	err := smtp.SendMail(ea.Address, smtp.PlainAuth("", ea.User, ea.Password, ea.Host), ea.From, []string{ea.To},
-		[]byte(strings.Replace(ea.Message, "\\n", "\n", 0)))
+func (ea *EMailAction) Run(ctx context.Context, eventManager interfaces.EventManager) error {
+	logger.Debugf(" [%s] action: email to %s", interfaces.RunIDFromContext(ctx), ea.To)
+	err := ea.send(ctx, []byte(strings.Replace(ea.Message, "\\n", "\n", -1)))
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// send does what smtp.SendMail does, but closes the connection when ctx is done.
+func (ea *EMailAction) send(ctx context.Context, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", ea.Address)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, ea.Host)
 	if err != nil {
-		logger.Errorf("Error sending email: %s", err)
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: ea.Host}); err != nil {
+			return err
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		if err = c.Auth(smtp.PlainAuth("", ea.User, ea.Password, ea.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(ea.From); err != nil {
+		return err
+	}
+	if err = c.Rcpt(ea.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
 	}
+	return c.Quit()
 }
@@ -1,53 +1,62 @@
 package actions
 
 import (
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 	log "github.com/Sirupsen/logrus"
-
+	"time"
 )
 
-// map with factory methods for producing bridges
-var ActionFactories = map[string]func(map[string]interface{}) interfaces.Action{
-	// HUE bridge
-	"wait": NewWaitAction,
+// map with factory methods for producing actions
+var ActionFactories = map[string]func(*config.Section) interfaces.Action{
+	"wait":    NewWaitAction,
 	"trigger": NewTriggerAction,
-	"http": NewHttpAction,
-	"email": NewEmailAction,
+	"http":    NewHttpAction,
+	"email":   NewEmailAction,
 }
 
 var logger = log.New()
 
-func NewWaitAction(config map[string]interface{}) interfaces.Action {
+func init() {
+	logger.Hooks.Add(config.RedactHook{})
+}
+
+func NewWaitAction(config *config.Section) interfaces.Action {
 	return new(WaitAction).Initialize(config)
 }
 
-func NewEmailAction(config map[string]interface{}) interfaces.Action {
+func NewEmailAction(config *config.Section) interfaces.Action {
 	return new(EMailAction).Initialize(config)
 }
 
-func NewTriggerAction(config map[string]interface{}) interfaces.Action {
+func NewTriggerAction(config *config.Section) interfaces.Action {
 	return new(TriggerAction).Initialize(config)
 }
 
-func NewHttpAction(config map[string]interface{}) interfaces.Action {
+func NewHttpAction(config *config.Section) interfaces.Action {
 	return new(HttpAction).Initialize(config)
 }
 
-func ParseActions(config []interface{}) []interfaces.Action {
-	actions := make([]interfaces.Action,0)
+// ParseActions instantiates the actions of a rule. Problems are recorded
+// in the configuration sections.
+func ParseActions(configs []*config.Section) []interfaces.Action {
+	actions := make([]interfaces.Action, 0)
 	logger.Debugf("Parse actions")
-	for n,aConfig := range config {
-		config := aConfig.(map[string]interface{})
-		actionType := config["type"].(string)
+	for n, config := range configs {
+		actionType := config.String("type")
 		logger.Debugf(" -> %d: Action type %s", n, actionType)
-		if factory,found := ActionFactories[actionType]; found {
-			actions = append(actions, factory(config))
-		} else {
-			logger.Debugf("** ERROR type %s not found **", )
+		factory, found := ActionFactories[actionType]
+		if !found {
+			if actionType != "" {
+				config.Errorf("type", "unknown action type %q", actionType)
+			}
+			continue
 		}
-
-
+		action := factory(config)
+		if timeout := config.OptionalNumber("timeout", 0); timeout > 0 {
+			action = &TimeoutAction{Timeout: time.Duration(timeout * float64(time.Second)), Action: action}
+		}
+		actions = append(actions, action)
 	}
 	return actions
 }
-
@@ -1,6 +1,9 @@
 package actions
 
 import (
+	"context"
+	"fmt"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 	"net/http"
 )
@@ -10,18 +13,26 @@ type HttpAction struct {
 	Format string
 }
 
-func (ha *HttpAction) Initialize(config map[string]interface{}) *HttpAction {
-	ha.Method = config["method"].(string)
-	ha.Format = config["format"].(string)
+func (ha *HttpAction) Initialize(config *config.Section) *HttpAction {
+	ha.Method = config.String("method")
+	ha.Format = config.String("format")
 	return ha
 }
 
-func (ha *HttpAction) Run(eventManager interfaces.EventManager, id string) {
-	logger.Debugf(" [%s] action: HTTP %s to %s", id, ha.Method, ha.Format)
-	req,_:=http.NewRequest(ha.Method, ha.Format, nil)
-	response,err := http.DefaultClient.Do(req)
+func (ha *HttpAction) Run(ctx context.Context, eventManager interfaces.EventManager) error {
+	logger.Debugf(" [%s] action: HTTP %s to %s", interfaces.RunIDFromContext(ctx), ha.Method, ha.Format)
+	req, err := http.NewRequest(ha.Method, ha.Format, nil)
 	if err != nil {
-		logger.Panicf("Error %s", err)
+		return err
 	}
+	response, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
 	logger.Infof("Request ended with status %d: %s", response.StatusCode, response.Status)
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %s %s: %s", ha.Method, ha.Format, response.Status)
+	}
+	return nil
 }
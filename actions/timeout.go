@@ -0,0 +1,20 @@
+package actions
+
+import (
+	"context"
+	"github.com/cpo/events/interfaces"
+	"time"
+)
+
+// TimeoutAction cancels the wrapped action when it takes longer than Timeout.
+// ParseActions wraps every action that has a "timeout" (in seconds) configured.
+type TimeoutAction struct {
+	Timeout time.Duration
+	Action  interfaces.Action
+}
+
+func (ta *TimeoutAction) Run(ctx context.Context, eventManager interfaces.EventManager) error {
+	ctx, cancel := context.WithTimeout(ctx, ta.Timeout)
+	defer cancel()
+	return ta.Action.Run(ctx, eventManager)
+}
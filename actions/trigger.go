@@ -1,19 +1,55 @@
 package actions
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 )
 
 type TriggerAction struct {
 	URL string
+	// Payload replaces the payload of the URL when set. Anything but a
+	// string is sent as JSON.
+	Payload *string
+	// Options are passed to the bridge as attributes of the event, e.g.
+	// qos and retain for MQTT
+	Options map[string]interface{}
 }
 
-func (ta *TriggerAction) Initialize(config map[string]interface{}) *TriggerAction {
-	ta.URL = config["trigger"].(string)
+func (ta *TriggerAction) Initialize(config *config.Section) *TriggerAction {
+	ta.URL = config.String("trigger")
+	if _, err := interfaces.ParseEvent(ta.URL); ta.URL != "" && err != nil {
+		config.Errorf("trigger", "%s", err)
+	}
+	if payload, found := config.Raw()["payload"]; found {
+		str, ok := payload.(string)
+		if !ok {
+			data, _ := json.Marshal(payload)
+			str = string(data)
+		}
+		ta.Payload = &str
+	}
+	if options := config.OptionalSection("options"); options != nil {
+		ta.Options = options.Raw()
+	}
 	return ta
 }
 
-func (ta *TriggerAction) Run(eventManager interfaces.EventManager, id string) {
-	logger.Debugf(" [%s] action: trigger URL %s", id, ta.URL)
-	eventManager.Trigger(ta.URL)
+func (ta *TriggerAction) Run(ctx context.Context, eventManager interfaces.EventManager) error {
+	logger.Debugf(" [%s] action: trigger URL %s", interfaces.RunIDFromContext(ctx), ta.URL)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	event, err := interfaces.ParseEvent(ta.URL)
+	if err != nil {
+		return err
+	}
+	if ta.Payload != nil {
+		event.Payload = *ta.Payload
+	}
+	for key, value := range ta.Options {
+		event.Attributes[key] = value
+	}
+	return eventManager.Trigger(ctx, event)
 }
@@ -1,6 +1,8 @@
 package actions
 
 import (
+	"context"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 	"time"
 )
@@ -9,12 +11,20 @@ type WaitAction struct {
 	Seconds int
 }
 
-func (wa *WaitAction) Initialize(config map[string]interface{}) *WaitAction {
-	wa.Seconds = int(config["seconds"].(float64))
+func (wa *WaitAction) Initialize(config *config.Section) *WaitAction {
+	wa.Seconds = config.Int("seconds")
+	config.AtLeast("seconds", 0)
 	return wa
 }
 
-func (wa *WaitAction) Run(eventManager interfaces.EventManager, id string) {
-	logger.Debugf(" [%s] action: Wait for %d seconds", id, wa.Seconds)
-	time.Sleep(time.Duration(wa.Seconds) * time.Second)
+func (wa *WaitAction) Run(ctx context.Context, eventManager interfaces.EventManager) error {
+	logger.Debugf(" [%s] action: Wait for %d seconds", interfaces.RunIDFromContext(ctx), wa.Seconds)
+	timer := time.NewTimer(time.Duration(wa.Seconds) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
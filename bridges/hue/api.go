@@ -0,0 +1,98 @@
+package hue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// get reads path of the v1 API, e.g. "groups/1", into v
+func (hue *HueBridge) get(ctx context.Context, path string, v interface{}) error {
+	return hue.request(ctx, "GET", path, nil, v)
+}
+
+// put sends body to path of the v1 API, e.g. "lights/3/state". The bridge
+// answers with a list holding a success or an error object for every
+// attribute, see checkResult.
+func (hue *HueBridge) put(ctx context.Context, path string, body interface{}) ([]interface{}, error) {
+	result := []interface{}{}
+	err := hue.request(ctx, "PUT", path, body, &result)
+	return result, err
+}
+
+func (hue *HueBridge) request(ctx context.Context, method string, path string, body interface{}, v interface{}) error {
+	hue.mutex.RLock()
+	host := hue.host
+	hue.mutex.RUnlock()
+	if host == "" {
+		return fmt.Errorf("hue bridge %s is not connected", hue.id)
+	}
+
+	var content io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s/api/%s/%s", host, hue.apiKey, path), content)
+	if err != nil {
+		return apiError(method, path, err)
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return apiError(method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return apiError(method, path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		// errors like an unknown apiKey come as a list instead of the
+		// object asked for
+		result := []interface{}{}
+		if json.Unmarshal(data, &result) == nil {
+			if err := resultError(result); err != nil {
+				return fmt.Errorf("%s %s: %s", method, path, err)
+			}
+		}
+		return fmt.Errorf("%s %s: %s", method, path, err)
+	}
+	return nil
+}
+
+// resultError returns the first error object in the result of a request
+func resultError(result []interface{}) error {
+	for _, item := range result {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if apiError, found := object["error"].(map[string]interface{}); found {
+			return fmt.Errorf("%v", apiError["description"])
+		}
+	}
+	return nil
+}
+
+// apiError leaves out the URL of transport errors, which contains the
+// apiKey
+func apiError(method string, path string, err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return fmt.Errorf("%s %s: %s", method, path, err)
+}
@@ -0,0 +1,46 @@
+package hue
+
+import "github.com/cpo/events/config"
+
+// Config is the configuration of a HUE bridge
+type Config struct {
+	Name        string
+	Description string
+	// Host is the address of the bridge, with an optional port. When it
+	// is empty the bridge is discovered, by BridgeID if there are several.
+	Host     string
+	BridgeID string
+	APIKey   string
+	// Mode is how changes are noticed: poll, eventstream (Hue API v2) or
+	// auto, which uses the event stream when the bridge offers it
+	Mode string
+	// InsecureSkipVerify turns off checking the certificate of the event
+	// stream
+	InsecureSkipVerify bool
+	PollInterval       int
+	// LightPollInterval is the poll interval of lights and groups in ms,
+	// 0 disables their events
+	LightPollInterval int
+}
+
+func ParseConfig(s *config.Section) Config {
+	c := Config{
+		Name:               s.String("name"),
+		Description:        s.OptionalString("description", ""),
+		Host:               s.OptionalString("host", ""),
+		BridgeID:           s.OptionalString("bridgeId", ""),
+		APIKey:             s.String("apiKey"),
+		Mode:               s.OneOf("mode", "poll", "poll", "eventstream", "auto"),
+		InsecureSkipVerify: s.OptionalBool("insecureSkipVerify", false),
+		LightPollInterval:  s.OptionalInt("lightPollInterval", 1000),
+	}
+	if c.Mode == "eventstream" {
+		// not needed, the event stream replaces polling
+		c.PollInterval = s.OptionalInt("pollInterval", 200)
+	} else {
+		c.PollInterval = s.Int("pollInterval")
+	}
+	s.AtLeast("pollInterval", 1)
+	s.AtLeast("lightPollInterval", 0)
+	return c
+}
@@ -0,0 +1,119 @@
+package hue
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/go-hue/portal"
+)
+
+const ssdpAddress = "239.255.255.250:1900"
+
+// DiscoveryTimeout is how long Discover listens for bridges to answer
+var DiscoveryTimeout = 3 * time.Second
+
+// Discovered is a bridge found on the network
+type Discovered struct {
+	ID   string
+	Host string
+}
+
+// Discover finds the Hue bridges on the local network with SSDP. When
+// none answer, it asks the discovery service of Philips.
+func Discover() ([]Discovered, error) {
+	found, err := discoverSSDP(DiscoveryTimeout)
+	if err != nil {
+		logger.Debugf("SSDP discovery of HUE bridges: %s", err)
+	}
+	if len(found) > 0 {
+		return found, nil
+	}
+
+	pp, err := portal.GetPortal()
+	if err != nil {
+		return nil, fmt.Errorf("no HUE bridge answered on the network and the discovery service failed: %s", err)
+	}
+	for _, p := range pp {
+		found = append(found, Discovered{ID: p.ID, Host: p.InternalIPAddress})
+	}
+	return found, nil
+}
+
+// Locate returns the address of the bridge with the given ID, or of the
+// only bridge found when bridgeID is empty.
+func Locate(bridgeID string) (string, error) {
+	found, err := Discover()
+	if err != nil {
+		return "", err
+	}
+	ids := make([]string, len(found))
+	for n, bridge := range found {
+		if bridgeID != "" && strings.EqualFold(bridge.ID, bridgeID) {
+			return bridge.Host, nil
+		}
+		ids[n] = bridge.ID
+	}
+	switch {
+	case len(found) == 0:
+		return "", fmt.Errorf("no HUE bridge found")
+	case bridgeID != "":
+		return "", fmt.Errorf("HUE bridge %s not found, found %s", bridgeID, strings.Join(ids, ", "))
+	case len(found) > 1:
+		return "", fmt.Errorf("found HUE bridges %s, configure \"host\" or \"bridgeId\"", strings.Join(ids, ", "))
+	}
+	return found[0].Host, nil
+}
+
+// discoverSSDP sends an M-SEARCH and collects the answers of Hue bridges,
+// which carry a hue-bridgeid header.
+func discoverSSDP(timeout time.Duration) ([]Discovered, error) {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: ssdp:all\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), addr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	seen := make(map[string]bool)
+	found := []Discovered{}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// the deadline ends the search
+			return found, nil
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		id := resp.Header.Get("hue-bridgeid")
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if id == "" || err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		found = append(found, Discovered{ID: id, Host: location.Host})
+	}
+}
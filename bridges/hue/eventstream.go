@@ -0,0 +1,264 @@
+package hue
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	logger "github.com/Sirupsen/logrus"
+)
+
+const eventStreamRetry = 10 * time.Second
+
+var streamURL = "https://%s/eventstream/clip/v2"
+
+// streamMessage is one message of the v2 event stream. Only the v1 ID of
+// the changed resources is used: they are read again with the v1 API, so
+// the events are the same as when polling.
+type streamMessage struct {
+	Type string `json:"type"`
+	Data []struct {
+		IDV1 string `json:"id_v1"`
+	} `json:"data"`
+}
+
+// streamState is the last known state of all resources, by path
+type streamState struct {
+	client  *http.Client
+	sensors map[string]SensorState
+	lights  map[string]attributes
+}
+
+// streamEvents consumes the server-sent events of /eventstream/clip/v2
+// and dispatches an event for every attribute that changed. The stream
+// is opened again when it ends. With fallback, the bridge is polled
+// instead when the stream cannot be opened the first time, e.g. because
+// the bridge does not support API v2.
+func (hue *HueBridge) streamEvents(done <-chan struct{}, fallback bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	state := &streamState{}
+	first := true
+	failures := 0
+	for {
+		opened, err := hue.readEventStream(ctx, state)
+		if ctx.Err() != nil {
+			logger.Debugf("Stop event stream of HUE bridge %s", hue.id)
+			return
+		}
+		if first && fallback && !opened {
+			logger.Infof("HUE bridge %s has no event stream, polling instead: %s", hue.id, err)
+			hue.startPolling(done)
+			return
+		}
+		first = false
+		if opened {
+			failures = 0
+		} else if failures++; failures >= maxFailures {
+			hue.fail(fmt.Errorf("opening the event stream failed %d times in a row: %s", failures, err))
+			return
+		}
+		logger.Warnf("Event stream of HUE bridge %s ended: %s", hue.id, err)
+		select {
+		case <-done:
+			return
+		case <-time.After(eventStreamRetry):
+		}
+	}
+}
+
+// readEventStream reads the stream until it ends. opened tells whether
+// the stream could be opened; errors opening it are returned before
+// anything is dispatched.
+func (hue *HueBridge) readEventStream(ctx context.Context, state *streamState) (opened bool, err error) {
+	if state.client == nil {
+		if state.client, err = hue.streamClient(ctx); err != nil {
+			return false, err
+		}
+	}
+	hue.mutex.RLock()
+	host := hue.host
+	hue.mutex.RUnlock()
+	req, err := http.NewRequest("GET", fmt.Sprintf(streamURL, host), nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("hue-application-key", hue.apiKey)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET /eventstream/clip/v2: %s", resp.Status)
+	}
+	logger.Infof("Reading event stream of HUE bridge %s", hue.id)
+
+	// changes while the stream was closed are not reported
+	if err := hue.readState(ctx, state); err != nil {
+		return false, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			// comments, event IDs and the empty line ending an event
+			continue
+		}
+		messages := []streamMessage{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line[5:])), &messages); err != nil {
+			logger.Debugf("Event stream of HUE bridge %s: %s", hue.id, err)
+			continue
+		}
+		for _, resource := range changedResources(messages) {
+			if err := hue.refresh(ctx, state, resource); err != nil {
+				logger.Debugf("Event stream of HUE bridge %s: %s: %s", hue.id, resource, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, fmt.Errorf("closed by the bridge")
+}
+
+// streamClient returns the client for the event stream. The bridge uses a
+// certificate signed by Signify, which is not in the system roots, so
+// only its common name is checked: it is the ID of the bridge. Unless
+// configured, the ID is read from the bridge.
+func (hue *HueBridge) streamClient(ctx context.Context) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if !hue.config.InsecureSkipVerify {
+		bridgeID := hue.config.BridgeID
+		if bridgeID == "" {
+			bridgeConfig := struct {
+				BridgeID string `json:"bridgeid"`
+			}{}
+			if err := hue.get(ctx, "config", &bridgeConfig); err != nil {
+				return nil, err
+			}
+			bridgeID = bridgeConfig.BridgeID
+		}
+		tlsConfig.VerifyPeerCertificate = verifyBridgeID(bridgeID)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// verifyBridgeID checks that the certificate of the bridge is issued to
+// bridgeID
+func verifyBridgeID(bridgeID string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("the bridge sent no certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if bridgeID == "" || !strings.EqualFold(cert.Subject.CommonName, bridgeID) {
+			return fmt.Errorf("certificate of %q does not belong to bridge %q", cert.Subject.CommonName, bridgeID)
+		}
+		return nil
+	}
+}
+
+// changedResources returns the v1 paths of the updated resources, e.g.
+// sensors/12, each once
+func changedResources(messages []streamMessage) []string {
+	seen := make(map[string]bool)
+	resources := []string{}
+	for _, message := range messages {
+		if message.Type != "update" {
+			continue
+		}
+		for _, data := range message.Data {
+			resource := strings.TrimPrefix(data.IDV1, "/")
+			if resource != "" && !seen[resource] {
+				seen[resource] = true
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources
+}
+
+// readState reads the state of all sensors, and of all lights and groups
+// unless their events are turned off
+func (hue *HueBridge) readState(ctx context.Context, state *streamState) error {
+	allSensors := make(map[string]rawSensor)
+	if err := hue.get(ctx, "sensors", &allSensors); err != nil {
+		return err
+	}
+	state.lights = make(map[string]attributes)
+	if hue.config.LightPollInterval > 0 {
+		allLights, err := hue.lightStates(ctx)
+		if err != nil {
+			return err
+		}
+		state.lights = allLights
+	}
+	state.sensors = make(map[string]SensorState, len(allSensors))
+	for id, sensor := range allSensors {
+		state.sensors[id] = NewSensorState(id, sensor)
+	}
+	return nil
+}
+
+// refresh reads resource with the v1 API and dispatches its changes
+func (hue *HueBridge) refresh(ctx context.Context, state *streamState, resource string) error {
+	parts := strings.Split(resource, "/")
+	if len(parts) != 2 {
+		return nil
+	}
+	if parts[0] != "sensors" && hue.config.LightPollInterval == 0 {
+		return nil
+	}
+	switch parts[0] {
+	case "sensors":
+		sensor := rawSensor{}
+		if err := hue.get(ctx, resource, &sensor); err != nil {
+			return err
+		}
+		now := NewSensorState(parts[1], sensor)
+		if then, found := state.sensors[parts[1]]; found {
+			hue.triggerEventsBasedOnChange(then, now)
+		}
+		state.sensors[parts[1]] = now
+	case "lights":
+		l := light{}
+		if err := hue.get(ctx, resource, &l); err != nil {
+			return err
+		}
+		hue.updateLights(state, resource, lightAttributes(l))
+	case "groups":
+		group := groupState{}
+		if err := hue.get(ctx, resource, &group); err != nil {
+			return err
+		}
+		hue.updateLights(state, resource, groupAttributes(group))
+	}
+	return nil
+}
+
+func (hue *HueBridge) updateLights(state *streamState, resource string, now attributes) {
+	if then, found := state.lights[resource]; found {
+		hue.dispatchChanges(resource, then, now)
+	}
+	state.lights[resource] = now
+}
@@ -1,99 +1,141 @@
 package hue
 
 import (
-	"encoding/json"
-	"github.com/cpo/events/interfaces"
-	"github.com/cpo/go-hue/groups"
-	"github.com/cpo/go-hue/lights"
-	"github.com/cpo/go-hue/portal"
-	"github.com/cpo/go-hue/sensors"
-	logger "github.com/Sirupsen/logrus"
+	"context"
+	"fmt"
 	"sync"
-	"time"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
 )
 
 type HueBridge struct {
 	id           string
+	config       Config
 	apiKey       string
-	wg           sync.WaitGroup
 	eventManager interfaces.EventManager
 	pollInterval int64
+	stop         chan struct{}
+	// failed is signalled when the pollers or the event stream failed
+	// too often in a row
+	failed chan error
+	mutex  sync.RWMutex
+	// host is the address of the bridge once connected
+	host string
 }
 
 func NewHueBridge() interfaces.Bridge {
 	return new(HueBridge)
 }
 
-func (hue *HueBridge) Initialize(eventManager interfaces.EventManager, config map[string]interface{}) {
-	cfgStr, _ := json.Marshal(config)
-	hue.id = config["name"].(string)
-	hue.apiKey = config["apiKey"].(string)
+func (hue *HueBridge) Initialize(eventManager interfaces.EventManager, config *config.Section) {
+	hue.config = ParseConfig(config)
+	hue.id = hue.config.Name
+	hue.apiKey = hue.config.APIKey
 	hue.eventManager = eventManager
-	hue.pollInterval = int64(config["pollInterval"].(float64))
-	logger.Debugf("Initialize HUE bridge %s with %s", hue.GetID(), cfgStr)
+	hue.pollInterval = int64(hue.config.PollInterval)
+	hue.stop = make(chan struct{})
+	hue.failed = make(chan error, 1)
+	logger.Debugf("Initialize HUE bridge %s with %s", hue.GetID(), config.JSON())
 }
 
 func (hue *HueBridge) GetID() string {
 	return hue.id
 }
 
-func (hue *HueBridge) restoreConnection() {
-	if r := recover(); r != nil {
-		logger.Info("Restarting HUE bridge...")
-		time.Sleep(10 * time.Second)
-		hue.Connect()
-	}
+// event returns an event of this bridge, e.g. hue://hue1/lights/3/on#true
+func (hue *HueBridge) event(path string, value string) *interfaces.Event {
+	return interfaces.NewEvent("hue", hue.id, path, value)
 }
 
-func (hue *HueBridge) Connect() {
-	logger.Info("Connecting HUE bridge %s", hue.id)
-	defer hue.restoreConnection()
-	pp, err := portal.GetPortal()
-	if err != nil {
-		logger.Panic("portal.GetPortal() ERROR: ", err)
+// Connect looks up the bridge, reads its lights, groups and sensors and
+// watches them for changes until Stop is called. It returns an error when
+// maxFailures polls in a row failed, or the event stream could not be
+// opened that often.
+func (hue *HueBridge) Connect() error {
+	logger.Infof("Connecting HUE bridge %s", hue.id)
+	host := hue.config.Host
+	if host == "" {
+		var err error
+		if host, err = Locate(hue.config.BridgeID); err != nil {
+			return err
+		}
+		logger.Infof("Found HUE bridge %s at %s", hue.id, host)
 	}
-	ll := lights.New(pp[0].InternalIPAddress, hue.apiKey)
-	allLights, err := ll.GetAllLights()
-	if err != nil {
-		logger.Panic("lights.GetAllLights() ERROR: ", err)
+	hue.mutex.Lock()
+	hue.host = host
+	hue.mutex.Unlock()
+
+	allLights := make(map[string]light)
+	if err := hue.get(context.Background(), "lights", &allLights); err != nil {
+		return fmt.Errorf("reading lights: %s", err)
 	}
 	logger.Debugf("Lights")
 	logger.Debugf("------")
-	for _, l := range allLights {
-		logger.Debugf("ID: %d Name: %s", l.ID, l.Name)
+	for id, l := range allLights {
+		logger.Debugf("ID: %s Name: %s", id, l.Name)
 	}
-	gg := groups.New(pp[0].InternalIPAddress, hue.apiKey)
-	allGroups, err := gg.GetAllGroups()
-	if err != nil {
-		logger.Panic("groups.GetAllGroups() ERROR: ", err)
+	allGroups := make(map[string]groupState)
+	if err := hue.get(context.Background(), "groups", &allGroups); err != nil {
+		return fmt.Errorf("reading groups: %s", err)
 	}
 	logger.Debugf("Groups")
 	logger.Debugf("------")
-	for _, g := range allGroups {
-		logger.Debugf("ID: %d Name: %s", g.ID, g.Name)
+	for id, g := range allGroups {
+		logger.Debugf("ID: %s Name: %s", id, g.Name)
 	}
-	ss := sensors.New(pp[0].InternalIPAddress, hue.apiKey)
-	allSensors, err := ss.GetAllSensors()
-	if err != nil {
-		logger.Panic("groups.GetAllSensors() ERROR: ", err)
+	allSensors := make(map[string]rawSensor)
+	if err := hue.get(context.Background(), "sensors", &allSensors); err != nil {
+		return fmt.Errorf("reading sensors: %s", err)
 	}
 	logger.Debugf("Sensors")
 	logger.Debugf("------")
-	for _, g := range allSensors {
-		logger.Debugf("ID: %d Name: %s", g.ID, g.Name)
+	for id, s := range allSensors {
+		logger.Debugf("ID: %s Name: %s", id, s.Name)
+	}
+
+	// forget a failure of the previous connection
+	select {
+	case <-hue.failed:
+	default:
+	}
+	// done stops the pollers or the event stream of this connection
+	done := make(chan struct{})
+	defer close(done)
+	switch hue.config.Mode {
+	case "eventstream":
+		go hue.streamEvents(done, false)
+	case "auto":
+		go hue.streamEvents(done, true)
+	default:
+		hue.startPolling(done)
 	}
 
-	go hue.pollSensors(ss)
+	select {
+	case <-hue.stop:
+		return nil
+	case err := <-hue.failed:
+		return err
+	}
+}
 
-	hue.wg = sync.WaitGroup{}
-	hue.wg.Add(1)
-	hue.wg.Wait()
+func (hue *HueBridge) startPolling(done <-chan struct{}) {
+	go hue.pollSensors(done)
+	if hue.config.LightPollInterval > 0 {
+		go hue.pollLights(done)
+	}
+}
 
+// fail ends the connection, so that Connect returns err
+func (hue *HueBridge) fail(err error) {
+	select {
+	case hue.failed <- err:
+	default:
+	}
 }
 
 func (hue *HueBridge) Stop() {
 	logger.Debugf("Stop HUE bridge %s", hue.id)
-}
-func (hue *HueBridge) Trigger(uri string) {
-	logger.Debugf("Trigger bridge %s: %s", hue.id, uri)
+	close(hue.stop)
 }
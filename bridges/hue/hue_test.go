@@ -0,0 +1,627 @@
+package hue
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
+)
+
+const testAPIKey = "testkey"
+
+// fakeBridge serves the resources of the v1 API from memory and records
+// the commands it receives
+type fakeBridge struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	resources map[string]map[string]interface{}
+	// commands are the PUT requests, by path, e.g. lights/1/state
+	commands map[string]map[string]interface{}
+	// result is the answer to PUT requests, a success when nil
+	result []interface{}
+	// messages are sent as data of the event stream, which is not found
+	// when nil. opened is signalled when the stream is opened.
+	messages chan string
+	opened   chan struct{}
+}
+
+func newFakeBridge() *fakeBridge {
+	fb := &fakeBridge{
+		resources: map[string]map[string]interface{}{
+			"lights": {
+				"1": map[string]interface{}{"name": "Desk", "state": map[string]interface{}{"on": false, "bri": 100, "reachable": true}},
+			},
+			"groups": {
+				"1": map[string]interface{}{"name": "Kitchen", "action": map[string]interface{}{"on": false}, "state": map[string]interface{}{"any_on": true, "all_on": false}},
+			},
+			"sensors": {
+				"5": map[string]interface{}{"name": "Hall", "type": "ZLLPresence", "modelid": "SML001",
+					"state": map[string]interface{}{"presence": false, "lastupdated": "2020-01-01T10:00:00"}},
+			},
+			"config": {"bridgeid": "001788FFFE23BFC2"},
+		},
+		commands: make(map[string]map[string]interface{}),
+	}
+	fb.Server = httptest.NewServer(http.HandlerFunc(fb.serve))
+	return fb
+}
+
+func (fb *fakeBridge) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/eventstream/clip/v2" {
+		fb.serveEventStream(w, r)
+		return
+	}
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	if path[0] != testAPIKey {
+		json.NewEncoder(w).Encode([]interface{}{map[string]interface{}{"error": map[string]interface{}{"type": 1, "description": "unauthorized user"}}})
+		return
+	}
+	path = path[1:]
+
+	if r.Method == "PUT" {
+		command := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&command)
+		fb.commands[strings.Join(path, "/")] = command
+		result := fb.result
+		if result == nil {
+			result = []interface{}{map[string]interface{}{"success": command}}
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	resources, found := fb.resources[path[0]]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	switch len(path) {
+	case 1:
+		json.NewEncoder(w).Encode(resources)
+	case 2:
+		if resource, found := resources[path[1]]; found {
+			json.NewEncoder(w).Encode(resource)
+			return
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// enableEventStream makes the bridge offer the event stream of API v2
+func (fb *fakeBridge) enableEventStream() {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.messages = make(chan string)
+	fb.opened = make(chan struct{}, 1)
+}
+
+func (fb *fakeBridge) serveEventStream(w http.ResponseWriter, r *http.Request) {
+	fb.mutex.Lock()
+	messages, opened := fb.messages, fb.opened
+	fb.mutex.Unlock()
+	if messages == nil || r.Header.Get("hue-application-key") != testAPIKey {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprint(w, ": hi\n\n")
+	w.(http.Flusher).Flush()
+	select {
+	case opened <- struct{}{}:
+	default:
+	}
+	for n := 1; ; n++ {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-messages:
+			fmt.Fprintf(w, "id: %d:0\ndata: %s\n\n", n, data)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// send sends an update of the resources, e.g. /lights/1, on the event
+// stream
+func (fb *fakeBridge) send(t *testing.T, resources ...string) {
+	t.Helper()
+	data := []map[string]string{}
+	for _, resource := range resources {
+		data = append(data, map[string]string{"id": "8a3b2c1d", "id_v1": resource, "type": strings.Split(resource, "/")[1]})
+	}
+	message, _ := json.Marshal([]interface{}{map[string]interface{}{"type": "update", "data": data}})
+	select {
+	case fb.messages <- string(message):
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream not read")
+	}
+}
+
+// setState changes the state of a resource, e.g. "sensors", "5"
+func (fb *fakeBridge) setState(kind string, id string, key string, value interface{}) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	resource := fb.resources[kind][id].(map[string]interface{})
+	resource["state"].(map[string]interface{})[key] = value
+}
+
+func (fb *fakeBridge) command(path string) map[string]interface{} {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	return fb.commands[path]
+}
+
+func (fb *fakeBridge) host() string {
+	return strings.TrimPrefix(fb.URL, "http://")
+}
+
+// fakeManager collects the dispatched events
+type fakeManager struct {
+	events chan *interfaces.Event
+}
+
+func newFakeManager() *fakeManager {
+	return &fakeManager{events: make(chan *interfaces.Event, 100)}
+}
+
+func (fm *fakeManager) Dispatch(event *interfaces.Event)                           { fm.events <- event }
+func (fm *fakeManager) Trigger(ctx context.Context, event *interfaces.Event) error { return nil }
+func (fm *fakeManager) Start() error                                               { return nil }
+
+// expect waits for an event with the given URL, skipping others
+func (fm *fakeManager) expect(t *testing.T, url string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-fm.events:
+			if event.URL() == url {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no event %s", url)
+		}
+	}
+}
+
+func newTestBridge(t *testing.T, fm *fakeManager, values map[string]interface{}) *HueBridge {
+	t.Helper()
+	errs := config.Errors{}
+	hue := new(HueBridge)
+	hue.Initialize(fm, config.NewSection("bridges[0]", values, &errs))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return hue
+}
+
+func TestConnectWithHost(t *testing.T) {
+	fb := newFakeBridge()
+	defer fb.Close()
+	fm := newFakeManager()
+	hue := newTestBridge(t, fm, map[string]interface{}{
+		"name":              "hue1",
+		"apiKey":            testAPIKey,
+		"host":              fb.host(),
+		"pollInterval":      10.0,
+		"lightPollInterval": 10.0,
+	})
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- hue.Connect()
+	}()
+	// let the first polls record the state
+	time.Sleep(100 * time.Millisecond)
+
+	fb.setState("sensors", "5", "presence", true)
+	fb.setState("sensors", "5", "lastupdated", "2020-01-01T10:00:05")
+	fm.expect(t, "hue://hue1/sensors/5/presence#true")
+	fb.setState("lights", "1", "on", true)
+	fm.expect(t, "hue://hue1/lights/1/on#true")
+
+	hue.Stop()
+	select {
+	case err := <-connected:
+		if err != nil {
+			t.Errorf("Connect returned %s after Stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connect did not return after Stop")
+	}
+}
+
+func TestConnectFailsWhenBridgeGoesAway(t *testing.T) {
+	fb := newFakeBridge()
+	hue := newTestBridge(t, newFakeManager(), map[string]interface{}{
+		"name":              "hue1",
+		"apiKey":            testAPIKey,
+		"host":              fb.host(),
+		"pollInterval":      1.0,
+		"lightPollInterval": 1.0,
+	})
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- hue.Connect()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	fb.Close()
+	select {
+	case err := <-connected:
+		if err == nil || !strings.Contains(err.Error(), "in a row") {
+			t.Errorf("Connect returned %v, want the poll failures", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connect did not return when the bridge went away")
+	}
+}
+
+func TestConnectUnauthorized(t *testing.T) {
+	fb := newFakeBridge()
+	defer fb.Close()
+	hue := newTestBridge(t, newFakeManager(), map[string]interface{}{
+		"name":         "hue1",
+		"apiKey":       "wrong",
+		"host":         fb.host(),
+		"pollInterval": 10.0,
+	})
+	err := hue.Connect()
+	if err == nil || !strings.Contains(err.Error(), "unauthorized user") {
+		t.Errorf("Connect returned %v, want unauthorized user", err)
+	}
+}
+
+// plainEventStream reads the event stream of the fake bridge, which does
+// not use TLS. The returned function restores the URL.
+func plainEventStream() func() {
+	url := streamURL
+	streamURL = "http://%s/eventstream/clip/v2"
+	return func() { streamURL = url }
+}
+
+// connect runs Connect until the returned function is called, which
+// checks that Connect returned nil
+func connect(t *testing.T, hue *HueBridge) func() {
+	connected := make(chan error, 1)
+	go func() {
+		connected <- hue.Connect()
+	}()
+	return func() {
+		t.Helper()
+		hue.Stop()
+		select {
+		case err := <-connected:
+			if err != nil {
+				t.Errorf("Connect returned %s after Stop", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Connect did not return after Stop")
+		}
+	}
+}
+
+func waitOpened(t *testing.T, fb *fakeBridge) {
+	t.Helper()
+	select {
+	case <-fb.opened:
+		// let the stream read the current state
+		time.Sleep(100 * time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream not opened")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	defer plainEventStream()()
+	fb := newFakeBridge()
+	defer fb.Close()
+	fb.enableEventStream()
+	fm := newFakeManager()
+	hue := newTestBridge(t, fm, map[string]interface{}{
+		"name":   "hue1",
+		"apiKey": testAPIKey,
+		"host":   fb.host(),
+		"mode":   "eventstream",
+	})
+	stop := connect(t, hue)
+	waitOpened(t, fb)
+
+	fb.setState("sensors", "5", "presence", true)
+	fb.setState("sensors", "5", "lastupdated", "2020-01-01T10:00:05")
+	fb.send(t, "/sensors/5")
+	fm.expect(t, "hue://hue1/sensors/5/presence#true")
+	fb.setState("lights", "1", "on", true)
+	fb.send(t, "/lights/1")
+	fm.expect(t, "hue://hue1/lights/1/on#true")
+	stop()
+}
+
+func TestEventStreamWithoutLights(t *testing.T) {
+	defer plainEventStream()()
+	fb := newFakeBridge()
+	defer fb.Close()
+	fb.enableEventStream()
+	fm := newFakeManager()
+	hue := newTestBridge(t, fm, map[string]interface{}{
+		"name":              "hue1",
+		"apiKey":            testAPIKey,
+		"host":              fb.host(),
+		"mode":              "eventstream",
+		"lightPollInterval": 0.0,
+	})
+	stop := connect(t, hue)
+	waitOpened(t, fb)
+
+	fb.setState("lights", "1", "on", true)
+	fb.setState("sensors", "5", "presence", true)
+	fb.setState("sensors", "5", "lastupdated", "2020-01-01T10:00:05")
+	// the light is refreshed first, if at all
+	fb.send(t, "/lights/1", "/sensors/5")
+	select {
+	case event := <-fm.events:
+		if !strings.HasPrefix(event.URL(), "hue://hue1/sensors/5/") {
+			t.Errorf("dispatched %s, want no light events", event.URL())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sensor event")
+	}
+	stop()
+}
+
+func TestEventStreamFallback(t *testing.T) {
+	defer plainEventStream()()
+	// no event stream, like bridges before API v2
+	fb := newFakeBridge()
+	defer fb.Close()
+	fm := newFakeManager()
+	hue := newTestBridge(t, fm, map[string]interface{}{
+		"name":         "hue1",
+		"apiKey":       testAPIKey,
+		"host":         fb.host(),
+		"mode":         "auto",
+		"pollInterval": 10.0,
+	})
+	stop := connect(t, hue)
+	time.Sleep(100 * time.Millisecond)
+
+	fb.setState("sensors", "5", "presence", true)
+	fb.setState("sensors", "5", "lastupdated", "2020-01-01T10:00:05")
+	fm.expect(t, "hue://hue1/sensors/5/presence#true")
+	stop()
+}
+
+func TestChangedResources(t *testing.T) {
+	tests := []struct {
+		data      string
+		resources []string
+	}{
+		{`[{"type": "update", "data": [{"id_v1": "/sensors/5"}, {"id_v1": "/lights/1"}]}]`, []string{"sensors/5", "lights/1"}},
+		// once per resource, other types and resources without a v1 ID are ignored
+		{`[{"type": "update", "data": [{"id_v1": "/sensors/5"}, {"id": "8a3b2c1d"}]},
+		   {"type": "add", "data": [{"id_v1": "/lights/2"}]},
+		   {"type": "update", "data": [{"id_v1": "/sensors/5"}, {"id_v1": "/groups/1"}]}]`, []string{"sensors/5", "groups/1"}},
+		{`[]`, []string{}},
+	}
+	for _, test := range tests {
+		messages := []streamMessage{}
+		if err := json.Unmarshal([]byte(test.data), &messages); err != nil {
+			t.Fatal(err)
+		}
+		if resources := changedResources(messages); !reflect.DeepEqual(resources, test.resources) {
+			t.Errorf("%s: changed %v, want %v", test.data, resources, test.resources)
+		}
+	}
+}
+
+// TestStreamCertificate checks the common name of the certificate of the
+// event stream
+func TestStreamCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "001788fffe23bfc2"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		bridgeID string
+		insecure bool
+		ok       bool
+	}{
+		{"001788FFFE23BFC2", false, true},
+		{"001788FFFE23BFC3", false, false},
+		{"001788FFFE23BFC3", true, true},
+	}
+	for _, test := range tests {
+		hue := &HueBridge{id: "hue1", config: Config{BridgeID: test.bridgeID, InsecureSkipVerify: test.insecure}}
+		client, err := hue.streamClient(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("bridge %s, insecureSkipVerify %t: got %v", test.bridgeID, test.insecure, err)
+		}
+	}
+}
+
+func TestSensorChanges(t *testing.T) {
+	fm := newFakeManager()
+	hue := &HueBridge{id: "hue1", eventManager: fm}
+	sensor := func(button float64, lastUpdated string) SensorState {
+		return NewSensorState("10", rawSensor{
+			Type:   "ZLLSwitch",
+			State:  map[string]interface{}{"buttonevent": button, "lastupdated": lastUpdated},
+			Config: map[string]interface{}{"battery": 90.0, "reachable": true},
+		})
+	}
+
+	// the same button pressed again is reported, nothing else changed
+	hue.triggerEventsBasedOnChange(sensor(4002, "10:00:00"), sensor(4002, "10:00:05"))
+	// nothing happened
+	hue.triggerEventsBasedOnChange(sensor(4002, "10:00:05"), sensor(4002, "10:00:05"))
+	close(fm.events)
+
+	urls := []string{}
+	for event := range fm.events {
+		urls = append(urls, event.URL())
+	}
+	if len(urls) != 1 || urls[0] != "hue://hue1/sensors/10/button#4002" {
+		t.Errorf("dispatched %v, want the button only", urls)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	fb := newFakeBridge()
+	defer fb.Close()
+	hue := &HueBridge{id: "hue1", apiKey: testAPIKey, host: fb.host()}
+
+	tests := []struct {
+		url     string
+		path    string
+		command string
+	}{
+		{"bridge://hue1/lights/1/on", "lights/1/state", `{"on":true}`},
+		{"bridge://hue1/lights/Desk/brightness#128", "lights/1/state", `{"bri":128,"on":true}`},
+		{"bridge://hue1/lights/1/brightness#0", "lights/1/state", `{"on":false}`},
+		{"bridge://hue1/lights/1/toggle", "lights/1/state", `{"on":true}`},
+		// toggles on the state: a light of the group is on
+		{"bridge://hue1/groups/kitchen/toggle", "groups/1/action", `{"on":false}`},
+		{"bridge://hue1/scenes/AbC123/recall", "groups/0/action", `{"scene":"AbC123"}`},
+	}
+	for _, test := range tests {
+		event, _ := interfaces.ParseEvent(test.url)
+		if err := hue.Trigger(context.Background(), event); err != nil {
+			t.Errorf("%s: %s", test.url, err)
+			continue
+		}
+		command, _ := json.Marshal(fb.command(test.path))
+		if string(command) != test.command {
+			t.Errorf("%s: sent %s to %s, want %s", test.url, command, test.path, test.command)
+		}
+	}
+
+	for _, url := range []string{
+		"bridge://hue1/lights/1",
+		"bridge://hue1/lights/1/blink",
+		"bridge://hue1/lights/1/brightness#300",
+		"bridge://hue1/lights/Nowhere/on",
+		"bridge://hue1/sensors/5/on",
+	} {
+		event, _ := interfaces.ParseEvent(url)
+		if err := hue.Trigger(context.Background(), event); err == nil {
+			t.Errorf("%s: no error", url)
+		}
+	}
+
+	// errors in the body of a successful response
+	fb.mutex.Lock()
+	fb.result = []interface{}{map[string]interface{}{"error": map[string]interface{}{"type": 201, "description": "parameter, bri, is not modifiable. Device is set to off."}}}
+	fb.mutex.Unlock()
+	event, _ := interfaces.ParseEvent("bridge://hue1/lights/1/brightness#10")
+	if err := hue.Trigger(context.Background(), event); err == nil || !strings.Contains(err.Error(), "not modifiable") {
+		t.Errorf("Trigger returned %v, want the error of the bridge", err)
+	}
+}
+
+func TestTriggerNotConnected(t *testing.T) {
+	hue := &HueBridge{id: "hue1"}
+	event, _ := interfaces.ParseEvent("bridge://hue1/lights/1/on")
+	if err := hue.Trigger(context.Background(), event); err == nil {
+		t.Error("Trigger succeeded without a connection")
+	}
+}
+
+func TestTriggerCancelled(t *testing.T) {
+	fb := newFakeBridge()
+	defer fb.Close()
+	hue := &HueBridge{id: "hue1", apiKey: testAPIKey, host: fb.host()}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	event, _ := interfaces.ParseEvent("bridge://hue1/lights/Desk/toggle")
+	if err := hue.Trigger(ctx, event); err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Errorf("Trigger returned %v, want the cancellation", err)
+	}
+	if command := fb.command("lights/1/state"); command != nil {
+		t.Errorf("sent %v after the context was cancelled", command)
+	}
+}
+
+func TestPair(t *testing.T) {
+	defer func(retry time.Duration) { pairRetry = retry }(pairRetry)
+	pairRetry = 10 * time.Millisecond
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/api" || !strings.Contains(string(body), `"devicetype":"events#test"`) {
+			t.Errorf("unexpected request %s %s %s", r.Method, r.URL.Path, body)
+		}
+		requests++
+		if requests < 3 {
+			w.Write([]byte(`[{"error":{"type":101,"address":"","description":"link button not pressed"}}]`))
+			return
+		}
+		w.Write([]byte(`[{"success":{"username":"abc123"}}]`))
+	}))
+	defer server.Close()
+
+	apiKey, err := Pair(strings.TrimPrefix(server.URL, "http://"), "events#test", time.Second)
+	if err != nil || apiKey != "abc123" {
+		t.Errorf("Pair returned %q, %v, want abc123", apiKey, err)
+	}
+	if requests != 3 {
+		t.Errorf("Pair asked %d times, want 3", requests)
+	}
+}
+
+func TestPairTimeout(t *testing.T) {
+	defer func(retry time.Duration) { pairRetry = retry }(pairRetry)
+	pairRetry = 10 * time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"error":{"type":101,"address":"","description":"link button not pressed"}}]`))
+	}))
+	defer server.Close()
+
+	if _, err := Pair(strings.TrimPrefix(server.URL, "http://"), "events#test", 50*time.Millisecond); err != errLinkButton {
+		t.Errorf("Pair returned %v, want %v", err, errLinkButton)
+	}
+}
@@ -0,0 +1,131 @@
+package hue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	logger "github.com/Sirupsen/logrus"
+)
+
+// attributes are the state of one light, group or sensor, formatted as
+// event payloads
+type attributes map[string]string
+
+// light is a light as returned by the API
+type light struct {
+	Name  string `json:"name"`
+	State struct {
+		On        bool      `json:"on"`
+		Bri       uint8     `json:"bri"`
+		CT        uint16    `json:"ct"`
+		XY        []float64 `json:"xy"`
+		Reachable bool      `json:"reachable"`
+	} `json:"state"`
+}
+
+// groupState is a group as returned by the API
+type groupState struct {
+	Name  string `json:"name"`
+	State struct {
+		AllOn bool `json:"all_on"`
+		AnyOn bool `json:"any_on"`
+	} `json:"state"`
+}
+
+func lightAttributes(l light) attributes {
+	attrs := attributes{
+		"on":         fmt.Sprintf("%t", l.State.On),
+		"brightness": fmt.Sprintf("%d", l.State.Bri),
+		"reachable":  fmt.Sprintf("%t", l.State.Reachable),
+	}
+	// the names match the commands of Trigger
+	if l.State.CT != 0 {
+		attrs["ct"] = fmt.Sprintf("%d", l.State.CT)
+	}
+	if len(l.State.XY) == 2 {
+		attrs["color"] = fmt.Sprintf("%.4f,%.4f", l.State.XY[0], l.State.XY[1])
+	}
+	return attrs
+}
+
+func groupAttributes(group groupState) attributes {
+	return attributes{
+		"any_on": fmt.Sprintf("%t", group.State.AnyOn),
+		"all_on": fmt.Sprintf("%t", group.State.AllOn),
+	}
+}
+
+// pollLights dispatches an event for every attribute of a light or group
+// that changed since the previous poll, e.g. when someone uses the Hue
+// app or a switch. The first poll only records the state.
+func (hue *HueBridge) pollLights(done <-chan struct{}) {
+	var previous map[string]attributes
+	failures := 0
+	for {
+		current, err := hue.lightStates(context.Background())
+		if err != nil {
+			logger.Debugf("Polling lights of HUE bridge %s: %s", hue.id, err)
+			if failures++; failures >= maxFailures {
+				hue.fail(fmt.Errorf("polling lights failed %d times in a row: %s", failures, err))
+				return
+			}
+		} else {
+			failures = 0
+			if previous != nil {
+				for resource, attrs := range current {
+					if then, found := previous[resource]; found {
+						hue.dispatchChanges(resource, then, attrs)
+					}
+				}
+			}
+			previous = current
+		}
+		select {
+		case <-done:
+			logger.Debugf("Stop polling lights of HUE bridge %s", hue.id)
+			return
+		case <-time.After(time.Millisecond * time.Duration(hue.config.LightPollInterval)):
+		}
+	}
+}
+
+// lightStates returns the attributes of all lights and groups by their
+// path, e.g. lights/3
+func (hue *HueBridge) lightStates(ctx context.Context) (map[string]attributes, error) {
+	allLights := make(map[string]light)
+	if err := hue.get(ctx, "lights", &allLights); err != nil {
+		return nil, err
+	}
+	allGroups := make(map[string]groupState)
+	if err := hue.get(ctx, "groups", &allGroups); err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]attributes, len(allLights)+len(allGroups))
+	for id, l := range allLights {
+		states["lights/"+id] = lightAttributes(l)
+	}
+	for id, group := range allGroups {
+		states["groups/"+id] = groupAttributes(group)
+	}
+	return states, nil
+}
+
+// dispatchChanges dispatches one event per attribute whose value differs,
+// e.g. hue://hue1/lights/3/brightness#128
+func (hue *HueBridge) dispatchChanges(resource string, then attributes, now attributes) {
+	names := make([]string, 0, len(now))
+	for name, value := range now {
+		if then[name] != value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		event := hue.event(resource+"/"+name, now[name])
+		logger.Debugf(" state change %s", event)
+		hue.eventManager.Dispatch(event)
+	}
+}
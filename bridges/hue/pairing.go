@@ -0,0 +1,65 @@
+package hue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// the error type the API returns until the link button is pressed
+const linkButtonNotPressed = 101
+
+// pairRetry is how often Pair asks the bridge while waiting
+var pairRetry = time.Second
+
+type pairingResult struct {
+	Success *struct {
+		Username string `json:"username"`
+	} `json:"success"`
+	Error *struct {
+		Type        int    `json:"type"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// Pair creates a user on the bridge at host and returns its name, which
+// is the apiKey of the bridge configuration. The link button on the
+// bridge has to be pressed before timeout.
+func Pair(host string, deviceType string, timeout time.Duration) (string, error) {
+	body, _ := json.Marshal(map[string]string{"devicetype": deviceType})
+	deadline := time.Now().Add(timeout)
+	for {
+		username, err := requestUser(host, body)
+		if err != errLinkButton || time.Now().After(deadline) {
+			return username, err
+		}
+		time.Sleep(pairRetry)
+	}
+}
+
+var errLinkButton = fmt.Errorf("the link button was not pressed")
+
+func requestUser(host string, body []byte) (string, error) {
+	resp, err := httpClient.Post(fmt.Sprintf("http://%s/api", host), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	results := []pairingResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return "", fmt.Errorf("unexpected answer from %s: %s", host, err)
+	}
+	for _, result := range results {
+		if result.Success != nil {
+			return result.Success.Username, nil
+		}
+		if result.Error != nil && result.Error.Type == linkButtonNotPressed {
+			return "", errLinkButton
+		}
+		if result.Error != nil {
+			return "", fmt.Errorf("%s", result.Error.Description)
+		}
+	}
+	return "", fmt.Errorf("unexpected answer from %s", host)
+}
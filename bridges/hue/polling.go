@@ -1,94 +1,138 @@
 package hue
 
 import (
+	"context"
 	"fmt"
 	"time"
-	"github.com/cpo/go-hue/sensors"
+
 	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/interfaces"
 )
 
+// rawSensor is a sensor as returned by the API. State and config differ
+// per sensor type, so they are kept as read.
+type rawSensor struct {
+	Name    string                 `json:"name"`
+	Type    string                 `json:"type"`
+	ModelID string                 `json:"modelid"`
+	State   map[string]interface{} `json:"state"`
+	Config  map[string]interface{} `json:"config"`
+}
+
+// SensorState is the state of a sensor of any type, e.g. a ZLLSwitch,
+// ZLLPresence, ZLLLightLevel or a CLIP sensor.
 type SensorState struct {
-	ID               int
-	DeviceType       string
-	StateType        string
-	ButtonState      string
-	TemperatureState string
-	DaylightState    string
-	PresenceState    string
-	LastUpdated      string
+	ID          string
+	DeviceType  string
+	StateType   string
+	LastUpdated string
+	Attributes  attributes
 }
 
-func NewSensorState(sensor sensors.Sensor) SensorState {
-	return SensorState{
-		ID:               sensor.ID,
-		DeviceType:       sensor.ModelID,
-		ButtonState:      fmt.Sprintf("%d", sensor.State.ButtonEvent),
-		TemperatureState: fmt.Sprintf("%d", sensor.State.Temperature),
-		PresenceState:    fmt.Sprintf("%t", sensor.State.Presence),
-		DaylightState:    fmt.Sprintf("%t", sensor.State.Daylight),
-		LastUpdated:      sensor.State.LastUpdated,
+// attribute names that differ from the name in the API
+var sensorAttributeNames = map[string]string{
+	"buttonevent": "button",
+}
+
+// config values that are reported next to the state
+var sensorConfigAttributes = []string{"battery", "reachable"}
+
+func NewSensorState(id string, sensor rawSensor) SensorState {
+	state := SensorState{
+		ID:         id,
+		DeviceType: sensor.ModelID,
+		StateType:  sensor.Type,
+		Attributes: make(attributes),
 	}
+	for key, value := range sensor.State {
+		if key == "lastupdated" {
+			state.LastUpdated = interfaces.FormatValue(value)
+			continue
+		}
+		if name, found := sensorAttributeNames[key]; found {
+			key = name
+		}
+		state.Attributes[key] = interfaces.FormatValue(value)
+	}
+	for _, key := range sensorConfigAttributes {
+		if value, found := sensor.Config[key]; found {
+			state.Attributes[key] = interfaces.FormatValue(value)
+		}
+	}
+	return state
 }
 
-func (hue *HueBridge) pollSensors(srs *sensors.Sensors) {
-	previousSensorInfo := make(map[int]SensorState)
-	for true {
-		//logger.Debugf("Polling sensors")
-		sensorInfo, err := srs.GetAllSensors()
-		if err == nil {
-			if previousSensorInfo == nil {
-				// first call
-				logger.Debugf("Got %d sensors", len(sensorInfo))
-				for _, v := range sensorInfo {
-					previousSensorInfo[v.ID] = NewSensorState(v)
-				}
-			} else {
-				// diff the two
-				for _, sensor := range sensorInfo {
-					newSensorState := NewSensorState(sensor)
-					//logger.Debugf("Compare state %s", newSensorState.String())
-					if prevSensorState, found := previousSensorInfo[sensor.ID]; found {
-						hue.triggerEventsBasedOnChange(prevSensorState, newSensorState, sensor)
-					} else {
-						logger.Debugf("New sensor: %d", sensor.ID)
-					}
+// maxFailures is the number of polls in a row that may fail before the
+// bridge reconnects
+const maxFailures = 10
 
-					previousSensorInfo[sensor.ID] = newSensorState
+func (hue *HueBridge) pollSensors(done <-chan struct{}) {
+	var previousSensorInfo map[string]SensorState
+	failures := 0
+	for {
+		sensorInfo := make(map[string]rawSensor)
+		err := hue.get(context.Background(), "sensors", &sensorInfo)
+		if err != nil {
+			logger.Debugf("Polling sensors of HUE bridge %s: %s", hue.id, err)
+			if failures++; failures >= maxFailures {
+				hue.fail(fmt.Errorf("polling sensors failed %d times in a row: %s", failures, err))
+				return
+			}
+		} else if previousSensorInfo == nil {
+			// first call
+			logger.Debugf("Got %d sensors", len(sensorInfo))
+			previousSensorInfo = make(map[string]SensorState, len(sensorInfo))
+			for id, sensor := range sensorInfo {
+				previousSensorInfo[id] = NewSensorState(id, sensor)
+			}
+		} else {
+			// diff the two
+			for id, sensor := range sensorInfo {
+				newSensorState := NewSensorState(id, sensor)
+				if prevSensorState, found := previousSensorInfo[id]; found {
+					hue.triggerEventsBasedOnChange(prevSensorState, newSensorState)
+				} else {
+					logger.Debugf("New sensor: %s", id)
 				}
-
+				previousSensorInfo[id] = newSensorState
 			}
 		}
-		time.Sleep(time.Millisecond * time.Duration(hue.pollInterval))
+		if err == nil {
+			failures = 0
+		}
+
+		select {
+		case <-done:
+			logger.Debugf("Stop polling HUE bridge %s", hue.id)
+			return
+		case <-time.After(time.Millisecond * time.Duration(hue.pollInterval)):
+		}
 	}
 }
 
 func (state SensorState) String() string {
-	return fmt.Sprintf("%d/%s: @%s btn:%s tmp:%s daylt:%s pres:%s",
-		state.ID, state.DeviceType, state.LastUpdated,
-		state.ButtonState, state.TemperatureState, state.DaylightState, state.PresenceState)
+	return fmt.Sprintf("%s/%s: @%s %v", state.ID, state.DeviceType, state.LastUpdated, state.Attributes)
 }
 
-func (bridge *HueBridge) triggerEventsBasedOnChange(then SensorState, now SensorState, s sensors.Sensor) bool {
-	if then.LastUpdated == now.LastUpdated {
-		return false
-	}
-	if then != now {
-		logger.Debugf(" === Difference in state %s / %s", then, now)
-		event := ""
-		if then.ButtonState != now.ButtonState {
-			event = fmt.Sprintf("hue://%s/sensors/%d/button#%s", bridge.id, now.ID, now.ButtonState)
-		} else if then.PresenceState != now.PresenceState {
-			event = fmt.Sprintf("hue://%s/sensors/%d/presence#%s", bridge.id, now.ID, now.PresenceState)
-		} else if now.DaylightState != "false" {
-			event = fmt.Sprintf("hue://%s/sensors/%d/daylight#%s", bridge.id, now.ID, now.DaylightState)
-		} else if now.TemperatureState != "0" {
-			event = fmt.Sprintf("hue://%s/sensors/%d/temperature#%s", bridge.id, now.ID, now.TemperatureState)
-		} else {
-			return false
+// triggerEventsBasedOnChange dispatches one event per attribute that
+// changed. A button press is reported even when the same button was
+// pressed last time, because the sensor was updated.
+func (bridge *HueBridge) triggerEventsBasedOnChange(then SensorState, now SensorState) {
+	resource := "sensors/" + now.ID
+	if then.LastUpdated != now.LastUpdated {
+		if _, found := now.Attributes["button"]; found {
+			// forget the previous press, so it is reported again
+			then.Attributes = copyAttributes(then.Attributes)
+			delete(then.Attributes, "button")
 		}
-		logger.Debugf(" state change %s", event)
-		go bridge.eventManager.Dispatch(event)
-		return true
 	}
-	return false
+	bridge.dispatchChanges(resource, then.Attributes, now.Attributes)
+}
+
+func copyAttributes(attrs attributes) attributes {
+	c := make(attributes, len(attrs))
+	for name, value := range attrs {
+		c[name] = value
+	}
+	return c
 }
@@ -0,0 +1,201 @@
+package hue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/interfaces"
+)
+
+// Trigger controls lights, groups and scenes. The path of the event is
+// one of
+//
+//	lights/<id or name>/on|off|toggle
+//	lights/<id or name>/brightness#<0-254>
+//	lights/<id or name>/color#<rrggbb or x,y>
+//	groups/<id or name>/...      (the same commands as lights)
+//	scenes/<id>/recall
+func (hue *HueBridge) Trigger(ctx context.Context, event *interfaces.Event) error {
+	logger.Debugf("Trigger bridge %s: %s", hue.id, event)
+	parts := strings.Split(event.Path, "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("hue bridge %s: cannot trigger %s, expected <lights|groups|scenes>/<id>/<command>", hue.id, event.Path)
+	}
+	target, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return fmt.Errorf("hue bridge %s: %s", hue.id, err)
+	}
+
+	switch parts[0] {
+	case "lights", "groups":
+		return hue.triggerLights(ctx, parts[0], target, parts[2], event.Payload)
+	case "scenes":
+		if parts[2] != "recall" {
+			return fmt.Errorf("hue bridge %s: unknown scene command %q", hue.id, parts[2])
+		}
+		// group 0 contains all lights, the scene decides which ones change
+		result, err := hue.put(ctx, "groups/0/action", map[string]interface{}{"scene": target})
+		return hue.checkResult("scenes/"+target, result, err)
+	}
+	return fmt.Errorf("hue bridge %s: unknown resource %q", hue.id, parts[0])
+}
+
+// triggerLights sends a command to a light, or to all lights of a group
+func (hue *HueBridge) triggerLights(ctx context.Context, kind string, target string, command string, payload string) error {
+	id, err := hue.resourceID(ctx, kind, target)
+	if err != nil {
+		return err
+	}
+	resource := kind + "/" + id
+
+	var state map[string]interface{}
+	switch command {
+	case "on":
+		state = map[string]interface{}{"on": true}
+	case "off":
+		state = map[string]interface{}{"on": false}
+	case "toggle":
+		on, err := hue.isOn(ctx, kind, resource)
+		if err != nil {
+			return fmt.Errorf("hue bridge %s: %s: %s", hue.id, resource, err)
+		}
+		state = map[string]interface{}{"on": !on}
+	case "brightness":
+		bri, err := parseBrightness(payload)
+		if err != nil {
+			return fmt.Errorf("hue bridge %s: %s: %s", hue.id, resource, err)
+		}
+		state = map[string]interface{}{"on": bri > 0}
+		if bri > 0 {
+			state["bri"] = bri
+		}
+	case "color":
+		xy, err := parseColor(payload)
+		if err != nil {
+			return fmt.Errorf("hue bridge %s: %s: %s", hue.id, resource, err)
+		}
+		state = map[string]interface{}{"on": true, "xy": xy}
+	default:
+		return fmt.Errorf("hue bridge %s: unknown %s command %q", hue.id, strings.TrimSuffix(kind, "s"), command)
+	}
+
+	// lights take a state, groups an action for all their lights
+	endpoint := "/state"
+	if kind == "groups" {
+		endpoint = "/action"
+	}
+	result, err := hue.put(ctx, resource+endpoint, state)
+	return hue.checkResult(resource, result, err)
+}
+
+// isOn reads whether a light is on, or any light of a group. The action
+// of a group is the last command sent to it, so that is not used.
+func (hue *HueBridge) isOn(ctx context.Context, kind string, resource string) (bool, error) {
+	if kind == "groups" {
+		group := groupState{}
+		err := hue.get(ctx, resource, &group)
+		return group.State.AnyOn, err
+	}
+	l := light{}
+	err := hue.get(ctx, resource, &l)
+	return l.State.On, err
+}
+
+// resourceID returns the ID of the light or group with the given ID or
+// name
+func (hue *HueBridge) resourceID(ctx context.Context, kind string, target string) (string, error) {
+	if _, err := strconv.Atoi(target); err == nil {
+		return target, nil
+	}
+	named := make(map[string]struct {
+		Name string `json:"name"`
+	})
+	if err := hue.get(ctx, kind, &named); err != nil {
+		return "", fmt.Errorf("hue bridge %s: %s", hue.id, err)
+	}
+	ids := make([]string, 0, len(named))
+	for id := range named {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if strings.EqualFold(named[id].Name, target) {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("hue bridge %s: no %s named %q", hue.id, strings.TrimSuffix(kind, "s"), target)
+}
+
+// checkResult turns the error objects the Hue API returns in the body of
+// a successful HTTP response into an error.
+func (hue *HueBridge) checkResult(resource string, result []interface{}, err error) error {
+	if err == nil {
+		err = resultError(result)
+	}
+	if err != nil {
+		return fmt.Errorf("hue bridge %s: %s: %s", hue.id, resource, err)
+	}
+	return nil
+}
+
+// parseBrightness parses 0-254. 0 turns the light off.
+func parseBrightness(payload string) (uint8, error) {
+	bri, err := strconv.ParseUint(payload, 10, 8)
+	if err != nil || bri > 254 {
+		return 0, fmt.Errorf("expected brightness 0-254, got %q", payload)
+	}
+	return uint8(bri), nil
+}
+
+// parseColor parses either a hex RGB color (rrggbb, with or without a
+// leading #) or CIE xy coordinates (x,y).
+func parseColor(payload string) ([]float32, error) {
+	if strings.Contains(payload, ",") {
+		coords := strings.Split(payload, ",")
+		if len(coords) != 2 {
+			return nil, fmt.Errorf("expected x,y, got %q", payload)
+		}
+		xy := make([]float32, 2)
+		for n, coord := range coords {
+			v, err := strconv.ParseFloat(strings.TrimSpace(coord), 32)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("expected x,y between 0 and 1, got %q", payload)
+			}
+			xy[n] = float32(v)
+		}
+		return xy, nil
+	}
+
+	hex := strings.TrimPrefix(payload, "#")
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("expected color rrggbb or x,y, got %q", payload)
+	}
+	return rgbToXY(float64(rgb>>16&0xff)/255, float64(rgb>>8&0xff)/255, float64(rgb&0xff)/255), nil
+}
+
+// rgbToXY converts sRGB to CIE xy as described in the Hue developer
+// documentation. The bridge maps the result into the gamut of the light.
+func rgbToXY(r, g, b float64) []float32 {
+	gamma := func(c float64) float64 {
+		if c > 0.04045 {
+			return math.Pow((c+0.055)/1.055, 2.4)
+		}
+		return c / 12.92
+	}
+	r, g, b = gamma(r), gamma(g), gamma(b)
+	x := r*0.664511 + g*0.154324 + b*0.162028
+	y := r*0.283881 + g*0.668433 + b*0.047685
+	z := r*0.000088 + g*0.072310 + b*0.986039
+	if x+y+z == 0 {
+		// black, use the white point
+		return []float32{0.3227, 0.329}
+	}
+	return []float32{float32(x / (x + y + z)), float32(y / (x + y + z))}
+}
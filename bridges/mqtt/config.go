@@ -0,0 +1,23 @@
+package mqtt
+
+import "github.com/cpo/events/config"
+
+// Config is the configuration of a MQTT bridge
+type Config struct {
+	Name        string
+	Description string
+	Connection
+	Subscriptions []Subscription
+	// IgnoreOwn drops the messages the event manager published itself
+	IgnoreOwn bool
+}
+
+func ParseConfig(s *config.Section) Config {
+	return Config{
+		Name:          s.String("name"),
+		Description:   s.OptionalString("description", ""),
+		Connection:    ParseConnection(s),
+		Subscriptions: parseSubscriptions(s),
+		IgnoreOwn:     s.OptionalBool("ignoreOwnMessages", true),
+	}
+}
@@ -0,0 +1,289 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
+	"github.com/yosssi/gmq/mqtt/client"
+)
+
+// Connection is how to reach a broker. The MQTT bridge and the MQTT
+// publisher read it from the same keys.
+type Connection struct {
+	Host     string
+	Port     int
+	Proto    string
+	ClientID string
+	User     string
+	Password string
+	// TLS is nil unless "tls" is configured
+	TLS *tls.Config
+	// Birth is published after connecting. Will is published by the
+	// broker when the connection is lost, and by us before disconnecting.
+	Birth *Message
+	Will  *Message
+}
+
+// Message is a message to publish, like the birth message
+type Message struct {
+	Topic   string
+	Payload string
+	QoS     byte
+	Retain  bool
+}
+
+func ParseConnection(s *config.Section) Connection {
+	c := Connection{
+		Host:     s.String("host"),
+		Port:     s.Int("port"),
+		Proto:    s.OptionalString("proto", "tcp"),
+		ClientID: s.String("clientId"),
+		User:     s.OptionalString("user", ""),
+		Password: s.OptionalString("password", ""),
+	}
+	if s.Has("password") && c.User == "" {
+		s.Errorf("password", "needs a user")
+	}
+	if tlsConfig := s.OptionalSection("tls"); tlsConfig != nil {
+		c.TLS = parseTLS(tlsConfig, c.Host)
+	}
+	if birth := s.OptionalSection("birth"); birth != nil {
+		c.Birth = parseMessage(birth, "online")
+	}
+	if will := s.OptionalSection("will"); will != nil {
+		c.Will = parseMessage(will, "offline")
+	}
+	return c
+}
+
+// parseMessage reads a birth or will message. They are retained by
+// default, so that clients connecting later see the status.
+func parseMessage(s *config.Section, payload string) *Message {
+	m := &Message{
+		Topic:   s.String("topic"),
+		Payload: s.OptionalString("payload", payload),
+		QoS:     byte(s.OptionalInt("qos", 1)),
+		Retain:  s.OptionalBool("retain", true),
+	}
+	if m.QoS > 2 {
+		s.Errorf("qos", "expected 0, 1 or 2")
+	}
+	return m
+}
+
+func (m *Message) publishOptions() *client.PublishOptions {
+	return &client.PublishOptions{
+		QoS:       m.QoS,
+		Retain:    m.Retain,
+		TopicName: []byte(m.Topic),
+		Message:   []byte(m.Payload),
+	}
+}
+
+// parseTLS reads the CA and client certificate files, so missing files
+// are reported with the configuration
+//
+//	"tls": {
+//	  "ca": "/etc/ssl/broker-ca.pem",
+//	  "cert": "/etc/ssl/events.pem",
+//	  "key": "/etc/ssl/events.key",
+//	  "insecureSkipVerify": false
+//	}
+func parseTLS(s *config.Section, host string) *tls.Config {
+	tlsConfig := &tls.Config{
+		ServerName:         s.OptionalString("serverName", host),
+		InsecureSkipVerify: s.OptionalBool("insecureSkipVerify", false),
+	}
+
+	if ca := s.OptionalString("ca", ""); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			s.Errorf("ca", "%s", err)
+		} else {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				s.Errorf("ca", "no certificates in %s", ca)
+			}
+		}
+	}
+
+	cert := s.OptionalString("cert", "")
+	key := s.OptionalString("key", "")
+	if (cert == "") != (key == "") {
+		s.Errorf("cert", "cert and key are needed together")
+	} else if cert != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			s.Errorf("cert", "%s", err)
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+		}
+	}
+	return tlsConfig
+}
+
+// Address returns host:port
+func (c Connection) Address() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// URL describes the broker for logging
+func (c Connection) URL() string {
+	scheme := c.Proto
+	if c.TLS != nil {
+		scheme += "+tls"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Address())
+}
+
+// ConnectOptions returns the options to connect a client to the broker
+func (c Connection) ConnectOptions() *client.ConnectOptions {
+	opts := &client.ConnectOptions{
+		Network:   c.Proto,
+		Address:   c.Address(),
+		TLSConfig: c.TLS,
+		ClientID:  []byte(c.ClientID),
+	}
+	if c.User != "" {
+		opts.UserName = []byte(c.User)
+		opts.Password = []byte(c.Password)
+	}
+	if c.Will != nil {
+		opts.WillTopic = []byte(c.Will.Topic)
+		opts.WillMessage = []byte(c.Will.Payload)
+		opts.WillQoS = c.Will.QoS
+		opts.WillRetain = c.Will.Retain
+	}
+	return opts
+}
+
+// Client is a connection to a broker, kept by the MQTT bridge and the
+// MQTT publisher. It publishes the birth message after connecting and the
+// will before disconnecting, and dispatches connection events.
+type Client struct {
+	// name is how the owner is logged, e.g. "MQTT bridge mqtt1"
+	name         string
+	id           string
+	connection   Connection
+	eventManager interfaces.EventManager
+	// lost is signalled when the connection to the broker is lost
+	lost chan error
+	// mutex guards mqttClient, which is nil while not connected
+	mutex      sync.Mutex
+	mqttClient *client.Client
+}
+
+// ErrNotConnected is returned by Publish while there is no connection
+var ErrNotConnected = errors.New("not connected")
+
+func NewClient(name string, id string, connection Connection, eventManager interfaces.EventManager) *Client {
+	return &Client{
+		name:         name,
+		id:           id,
+		connection:   connection,
+		eventManager: eventManager,
+		lost:         make(chan error, 1),
+	}
+}
+
+// Run connects to the broker and calls connected, e.g. to subscribe. It
+// returns nil after stop is closed and the client disconnected, calling
+// disconnecting first, and an error when connecting failed or the
+// connection was lost. connected and disconnecting may be nil.
+func (c *Client) Run(stop <-chan struct{}, connected func(*client.Client) error, disconnecting func(*client.Client)) error {
+	logger.Infof("Connecting %s", c.name)
+	mqttClient := client.New(&client.Options{ErrorHandler: c.connectionLost})
+	defer mqttClient.Terminate()
+	// forget a loss of the previous connection
+	select {
+	case <-c.lost:
+	default:
+	}
+
+	logger.Infof("MQTT connecting client %s to %s", c.connection.ClientID, c.connection.URL())
+	if err := mqttClient.Connect(c.connection.ConnectOptions()); err != nil {
+		return fmt.Errorf("connecting to %s: %s", c.connection.URL(), err)
+	}
+	if connected != nil {
+		if err := connected(mqttClient); err != nil {
+			return err
+		}
+	}
+	if birth := c.connection.Birth; birth != nil {
+		if err := publish(mqttClient, birth.publishOptions()); err != nil {
+			logger.Warnf("%s cannot publish birth message: %s", c.name, err)
+		}
+	}
+	c.setClient(mqttClient)
+	defer c.setClient(nil)
+	logger.Debugf("%s connected.", c.name)
+	c.eventManager.Dispatch(ConnectionEvent(c.id, "connected"))
+
+	select {
+	case <-stop:
+	case err := <-c.lost:
+		return fmt.Errorf("lost connection: %s", err)
+	}
+
+	logger.Debugf("Stop %s", c.name)
+	c.setClient(nil)
+	if disconnecting != nil {
+		disconnecting(mqttClient)
+	}
+	// the broker only publishes the will when the connection is lost
+	if will := c.connection.Will; will != nil {
+		if err := publish(mqttClient, will.publishOptions()); err != nil {
+			logger.Warnf("%s cannot publish will: %s", c.name, err)
+		}
+	}
+	if err := mqttClient.Disconnect(); err != nil {
+		logger.Warnf("%s cannot disconnect: %s", c.name, err)
+	}
+	return nil
+}
+
+// Publish publishes a message, or returns ErrNotConnected
+func (c *Client) Publish(opts *client.PublishOptions) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.mqttClient == nil {
+		return ErrNotConnected
+	}
+	return publish(c.mqttClient, opts)
+}
+
+// publish remembers the message, so that subscriptions can ignore it
+func publish(mqttClient *client.Client, opts *client.PublishOptions) error {
+	published(string(opts.TopicName), opts.Message)
+	return mqttClient.Publish(opts)
+}
+
+func (c *Client) setClient(mqttClient *client.Client) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.mqttClient = mqttClient
+}
+
+// connectionLost is called by the client when the connection fails
+func (c *Client) connectionLost(err error) {
+	logger.Warnf("%s: %s", c.name, err)
+	c.eventManager.Dispatch(ConnectionEvent(c.id, "lost"))
+	select {
+	case c.lost <- err:
+	default:
+	}
+}
+
+// ConnectionEvent returns the event dispatched when the connection of a
+// bridge or publisher to its broker changes: mqtt://mqtt1/connection#lost
+// or #connected
+func ConnectionEvent(id string, state string) *interfaces.Event {
+	return interfaces.NewEvent("mqtt", id, "connection", state)
+}
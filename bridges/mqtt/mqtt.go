@@ -1,22 +1,21 @@
 package mqtt
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/yosssi/gmq/mqtt"
+	"github.com/cpo/events/config"
 	"github.com/yosssi/gmq/mqtt/client"
 	logger "github.com/Sirupsen/logrus"
-	"fmt"
 	"github.com/cpo/events/interfaces"
-	"github.com/yosssi/gmq/mqtt"
-	"strings"
-	"sync"
-	"time"
 )
 
 type MQTTBridge struct {
 	id           string
-	mqttClient   *client.Client
-	config       map[string]interface{}
-	wg           sync.WaitGroup
+	client       *Client
+	config       Config
+	stop         chan struct{}
 	eventManager interfaces.EventManager
 }
 
@@ -24,95 +23,143 @@ func NewMQTTBridge() interfaces.Bridge {
 	return new(MQTTBridge)
 }
 
-func (mq *MQTTBridge) Initialize(eventManager interfaces.EventManager, config map[string]interface{}) {
-	cfgStr, _ := json.Marshal(config)
-	mq.id = config["name"].(string)
-	mq.config = config
+func (mq *MQTTBridge) Initialize(eventManager interfaces.EventManager, config *config.Section) {
+	mq.config = ParseConfig(config)
+	mq.id = mq.config.Name
 	mq.eventManager = eventManager
-	logger.Debugf("Initialize MQTT bridge %s with %s", mq.GetID(), cfgStr)
+	mq.client = NewClient("MQTT bridge "+mq.id, mq.id, mq.config.Connection, eventManager)
+	mq.stop = make(chan struct{})
+	logger.Debugf("Initialize MQTT bridge %s with %s", mq.GetID(), config.JSON())
 }
 
 func (mq *MQTTBridge) GetID() string {
 	return mq.id
 }
 
-func (mq *MQTTBridge) Connect() {
-	logger.Infof("Connecting MQTT bridge %s", mq.id)
-	mq.mqttClient = client.New(&client.Options{})
-	defer mq.mqttClient.Terminate()
-	defer mq.connectRecovery()
-
-	logger.Infof("MQTT connecting client %s to %s://%s:%.0f",
-		mq.config["clientId"].(string),
-		mq.config["proto"].(string),
-		mq.config["host"].(string),
-		mq.config["port"].(float64))
+// Connect connects to the broker and subscribes. It returns nil once the
+// bridge is stopped, and an error when connecting failed or the
+// connection was lost.
+func (mq *MQTTBridge) Connect() error {
+	return mq.client.Run(mq.stop, mq.subscribe, mq.unsubscribe)
+}
 
-	err := mq.mqttClient.Connect(&client.ConnectOptions{
-		Network:  mq.config["proto"].(string),
-		Address:  mq.config["host"].(string) + ":" + fmt.Sprintf("%.0f", mq.config["port"].(float64)),
-		ClientID: []byte(mq.config["clientId"].(string)),
-	})
-	if err != nil {
-		logger.Panic(err)
+func (mq *MQTTBridge) subscribe(mqttClient *client.Client) error {
+	subReqs := make([]*client.SubReq, len(mq.config.Subscriptions))
+	for n, subscription := range mq.config.Subscriptions {
+		subReqs[n] = &client.SubReq{
+			TopicFilter: []byte(subscription.Topic),
+			QoS:         subscription.QoS,
+			Handler:     mq.handler(subscription),
+		}
 	}
+	if err := mqttClient.Subscribe(&client.SubscribeOptions{SubReqs: subReqs}); err != nil {
+		return fmt.Errorf("subscribing: %s", err)
+	}
+	return nil
+}
 
-	// Subscribe to topics.
-	err = mq.mqttClient.Subscribe(&client.SubscribeOptions{
-		SubReqs: []*client.SubReq{
-			&client.SubReq{
-				TopicFilter: []byte("#"),
-				QoS:         mqtt.QoS2,
-				// Define the processing of the message handler.
-				Handler: func(topicName, message []byte) {
-					logger.Debugf("MQTT topic=%s message=%s", string(topicName), string(message))
-					go mq.eventManager.Dispatch(fmt.Sprintf("mqtt://%s/%s#%s", mq.config["name"], topicName, string(message)))
-				},
-			},
-		},
-	})
+func (mq *MQTTBridge) unsubscribe(mqttClient *client.Client) {
+	topicFilters := make([][]byte, len(mq.config.Subscriptions))
+	for n, subscription := range mq.config.Subscriptions {
+		topicFilters[n] = []byte(subscription.Topic)
+	}
+	if err := mqttClient.Unsubscribe(&client.UnsubscribeOptions{TopicFilters: topicFilters}); err != nil {
+		logger.Warnf("MQTT bridge %s cannot unsubscribe: %s", mq.id, err)
+	}
+}
 
-	logger.Debugf("MQTT bridge %s connected.", mq.id)
+// handler turns the messages of a subscription into events
+func (mq *MQTTBridge) handler(subscription Subscription) client.MessageHandler {
+	return func(topicName, message []byte) {
+		logger.Debugf("MQTT topic=%s message=%s", string(topicName), string(message))
+		if mq.config.IgnoreOwn && isOwn(string(topicName), message) {
+			logger.Debugf("Ignoring own message on %s", string(topicName))
+			return
+		}
+		for _, event := range mq.events(subscription, string(topicName), string(message)) {
+			mq.eventManager.Dispatch(event)
+		}
+	}
+}
 
-	mq.wg = sync.WaitGroup{}
-	mq.wg.Add(1)
-	mq.wg.Wait()
+// events turns a message into events. Without fields, that is one event,
+// e.g. mqtt://mqtt1/tele/sonoff/STATE#{...}. With fields, every field
+// found in the JSON message is an event, its path appended to the path,
+// e.g. mqtt://mqtt1/tele/sonoff/SENSOR/ENERGY.Power#45.
+func (mq *MQTTBridge) events(subscription Subscription, topic string, payload string) []*interfaces.Event {
+	path := expand(subscription.Path, topic, payload)
+	if err := interfaces.ValidPath(path); err != nil {
+		logger.Warnf("MQTT bridge %s: ignoring message on %s: %s", mq.id, topic, err)
+		return nil
+	}
+	var decoded map[string]interface{}
+	if len(subscription.Fields) > 0 || subscription.JSONAttributes {
+		if err := json.Unmarshal([]byte(payload), &decoded); err != nil {
+			logger.Debugf("MQTT bridge %s: message on %s is no JSON object: %s", mq.id, topic, err)
+		}
+	}
 
-	logger.Debugf("Stop MQTT bridge %s", mq.id)
+	newEvent := func(path string, payload string) *interfaces.Event {
+		event := interfaces.NewEvent("mqtt", mq.id, path, payload)
+		if subscription.JSONAttributes {
+			for key, value := range decoded {
+				event.Attributes[key] = value
+			}
+		}
+		return event
+	}
 
-	// Unsubscribe from topics.
-	err = mq.mqttClient.Unsubscribe(&client.UnsubscribeOptions{
-		TopicFilters: [][]byte{
-			[]byte("/#"),
-		},
-	})
-	if err != nil {
-		logger.Panic(err)
+	if len(subscription.Fields) == 0 {
+		return []*interfaces.Event{newEvent(path, expand(subscription.Payload, topic, payload))}
 	}
-	if err := mq.mqttClient.Disconnect(); err != nil {
-		logger.Panic(err)
+	events := []*interfaces.Event{}
+	for _, field := range subscription.Fields {
+		if value, found := interfaces.Lookup(decoded, field); found {
+			events = append(events, newEvent(path+"/"+field, interfaces.FormatValue(value)))
+		}
 	}
+	return events
 }
 
-func (mq *MQTTBridge) connectRecovery() {
-	if r := recover(); r != nil {
-		logger.Debugf("Recovering connection for MQTT bridge %s", mq.id)
-		time.Sleep(3 * time.Second)
-		mq.Connect()
-	}
+func (mq *MQTTBridge) Stop() {
+	close(mq.stop)
 }
 
-func (mq *MQTTBridge) Stop() {
-	mq.wg.Done()
+// Trigger publishes the payload of event to the topic in its path, e.g.
+// bridge://mqtt1/sonoff-mylight/cmnd/Power1#ON. The "qos" (0-2) and
+// "retain" attributes of the event, set as options of the trigger
+// action, control how.
+func (mq *MQTTBridge) Trigger(ctx context.Context, event *interfaces.Event) error {
+	logger.Debugf("Publishing MQTT bridge %s: %s", mq.id, event)
+	opts, err := publishOptions(event)
+	if err != nil {
+		return fmt.Errorf("mqtt bridge %s: %s", mq.id, err)
+	}
+	if err := mq.client.Publish(opts); err != nil {
+		return fmt.Errorf("mqtt bridge %s: %s", mq.id, err)
+	}
+	return nil
 }
 
-func (mq *MQTTBridge) Trigger(uri string) {
-	logger.Debugf("Publishing MQTT bridge %s: %s", mq.id, uri)
-	lastIndex := strings.LastIndex(uri, "#")
-	var message string = ""
-	if lastIndex >= 0 {
-		message = uri[lastIndex:]
-		uri = uri[:lastIndex]
+func publishOptions(event *interfaces.Event) (*client.PublishOptions, error) {
+	opts := &client.PublishOptions{
+		QoS:       mqtt.QoS0,
+		TopicName: []byte(event.Path),
+		Message:   []byte(event.Payload),
+	}
+	if value, found := event.Attributes["qos"]; found {
+		qos, ok := value.(float64)
+		if !ok || (qos != 0 && qos != 1 && qos != 2) {
+			return nil, fmt.Errorf("qos must be 0, 1 or 2, got %v", value)
+		}
+		opts.QoS = byte(qos)
+	}
+	if value, found := event.Attributes["retain"]; found {
+		retain, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("retain must be true or false, got %v", value)
+		}
+		opts.Retain = retain
 	}
-	mq.mqttClient.Publish(&client.PublishOptions{TopicName: []byte(uri), Message: []byte(message)})
+	return opts, nil
 }
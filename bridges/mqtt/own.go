@@ -0,0 +1,47 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+)
+
+// how long a published message is remembered
+const ownMessageTTL = 10 * time.Second
+
+// own remembers the messages the event manager published, so that the
+// bridges can ignore them when a broker delivers them back. Otherwise a
+// rule reacting to a message could trigger itself.
+var own = struct {
+	sync.Mutex
+	messages  map[string]time.Time
+	lastPrune time.Time
+}{messages: make(map[string]time.Time)}
+
+// published records a message the event manager published
+func published(topic string, payload []byte) {
+	own.Lock()
+	defer own.Unlock()
+	now := time.Now()
+	if now.Sub(own.lastPrune) > ownMessageTTL {
+		for key, at := range own.messages {
+			if now.Sub(at) > ownMessageTTL {
+				delete(own.messages, key)
+			}
+		}
+		own.lastPrune = now
+	}
+	own.messages[ownKey(topic, payload)] = now
+}
+
+// isOwn reports whether the event manager published the message
+// recently. Every bridge and subscription receiving it ignores it.
+func isOwn(topic string, payload []byte) bool {
+	own.Lock()
+	defer own.Unlock()
+	published, found := own.messages[ownKey(topic, payload)]
+	return found && time.Since(published) <= ownMessageTTL
+}
+
+func ownKey(topic string, payload []byte) string {
+	return topic + "\x00" + string(payload)
+}
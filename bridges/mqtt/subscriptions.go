@@ -0,0 +1,100 @@
+package mqtt
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cpo/events/config"
+	"github.com/yosssi/gmq/mqtt"
+)
+
+// placeholder matches {topic}, {payload} and {n}, the nth level of the
+// topic counting from 0
+var placeholder = regexp.MustCompile(`\{(topic|payload|[0-9]+)\}`)
+
+// Subscription is a topic filter to subscribe to and how its messages
+// become events
+type Subscription struct {
+	Topic string
+	QoS   byte
+	// Path and Payload are templates of the event, by default {topic}
+	// and {payload}
+	Path    string
+	Payload string
+	// Fields are paths into a JSON message, e.g. ENERGY.Power. When set,
+	// every field becomes an event of its own.
+	Fields []string
+	// JSONAttributes attaches the decoded JSON message to the events
+	JSONAttributes bool
+}
+
+func parseSubscriptions(s *config.Section) []Subscription {
+	if !s.Has("subscriptions") {
+		return []Subscription{{Topic: "#", QoS: mqtt.QoS2, Path: "{topic}", Payload: "{payload}"}}
+	}
+	subscriptions := []Subscription{}
+	for _, sub := range s.List("subscriptions") {
+		subscription := Subscription{
+			Topic:          sub.String("topic"),
+			QoS:            byte(sub.OptionalInt("qos", 0)),
+			Path:           sub.OptionalString("path", "{topic}"),
+			Payload:        sub.OptionalString("payload", "{payload}"),
+			Fields:         sub.OptionalStrings("fields"),
+			JSONAttributes: sub.OptionalBool("jsonAttributes", false),
+		}
+		if subscription.QoS > mqtt.QoS2 {
+			sub.Errorf("qos", "expected 0, 1 or 2")
+		}
+		if strings.Contains(subscription.Path, "#") {
+			sub.Errorf("path", "must not contain '#'")
+		}
+		for _, field := range subscription.Fields {
+			if strings.Contains(field, "#") {
+				sub.Errorf("fields", "field %q must not contain '#'", field)
+			}
+		}
+		if !validFilter(subscription.Topic) {
+			sub.Errorf("topic", "invalid topic filter %q", subscription.Topic)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+// validFilter checks the wildcards: + is a whole level, # the last one
+func validFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for n, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || n != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// expand fills in a template with the topic and payload of a message.
+// Levels the topic doesn't have are empty.
+func expand(template string, topic string, payload string) string {
+	levels := strings.Split(topic, "/")
+	return placeholder.ReplaceAllStringFunc(template, func(p string) string {
+		switch name := p[1 : len(p)-1]; name {
+		case "topic":
+			return topic
+		case "payload":
+			return payload
+		default:
+			n, _ := strconv.Atoi(name)
+			if n < len(levels) {
+				return levels[n]
+			}
+			return ""
+		}
+	})
+}
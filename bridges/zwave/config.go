@@ -0,0 +1,92 @@
+package zwave
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cpo/events/config"
+)
+
+// Config is the configuration of a Z-Wave bridge
+type Config struct {
+	Name        string
+	Description string
+	Port        string
+	Devices     map[int]Device
+}
+
+// Device is the configuration of one node
+type Device struct {
+	// Type is the profile that turns updates into events, e.g. contact
+	Type string
+	// Name is used instead of the node ID in events and triggers
+	Name string
+	// Delta is the change of a numeric value, by name, that is reported.
+	// DefaultDelta applies to the other values.
+	Delta        map[string]float64
+	DefaultDelta float64
+}
+
+func (d Device) delta(name string) float64 {
+	if delta, found := d.Delta[name]; found {
+		return delta
+	}
+	return d.DefaultDelta
+}
+
+func ParseConfig(s *config.Section) Config {
+	c := Config{
+		Name:        s.String("name"),
+		Description: s.OptionalString("description", ""),
+		Port:        s.String("port"),
+		Devices:     make(map[int]Device),
+	}
+	devices := s.OptionalSection("devices")
+	if devices == nil {
+		return c
+	}
+	ids := make([]string, 0, len(devices.Raw()))
+	for id := range devices.Raw() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	names := make(map[string]string)
+	for _, id := range ids {
+		device := devices.Section(id)
+		address, err := strconv.Atoi(id)
+		if err != nil || address < 1 {
+			devices.Errorf(id, "expected a node ID")
+			continue
+		}
+		d := Device{Name: device.OptionalString("name", "")}
+		if device.Has("type") {
+			d.Type = device.OneOf("type", "", profileTypes()...)
+		}
+		parseDelta(device, &d)
+		if other, found := names[d.Name]; found && d.Name != "" {
+			device.Errorf("name", "duplicate name %q, also used by node %s", d.Name, other)
+		}
+		names[d.Name] = id
+		if _, err := strconv.Atoi(d.Name); err == nil {
+			device.Errorf("name", "must not be a number")
+		}
+		c.Devices[address] = d
+	}
+	return c
+}
+
+// parseDelta reads "delta", a number for all values or an object with a
+// number per value
+func parseDelta(s *config.Section, d *Device) {
+	if _, ok := s.Raw()["delta"].(map[string]interface{}); !ok {
+		d.DefaultDelta = s.OptionalNumber("delta", 0)
+		s.AtLeast("delta", 0)
+		return
+	}
+	deltas := s.Section("delta")
+	d.Delta = make(map[string]float64)
+	for name := range deltas.Raw() {
+		d.Delta[name] = deltas.Number(name)
+		deltas.AtLeast(name, 0)
+	}
+}
@@ -0,0 +1,198 @@
+package zwave
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stampzilla/gozwave"
+)
+
+// inclusionTimeout ends inclusion or exclusion mode when it was not
+// stopped, like most controllers do by themselves
+const inclusionTimeout = 60 * time.Second
+
+// healInterval spaces the neighbor updates of the nodes while healing,
+// so that the controller finishes one before the next starts
+var healInterval = 10 * time.Second
+
+// networkMode is inclusion, exclusion or healing, which the controller
+// does for a while. Only one runs at a time.
+type networkMode struct {
+	name string
+	// nodes are the nodes of the network when the mode started
+	nodes map[int]bool
+	end   chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+// stop ends the mode and waits until it ended
+func (m *networkMode) stop() {
+	m.once.Do(func() { close(m.end) })
+	<-m.done
+}
+
+// triggerController handles controller/include, exclude, heal and stop.
+// Include and exclude put the controller in that mode until stop is
+// triggered or inclusionTimeout passed. Then the nodes that were added
+// or removed meanwhile are dispatched, e.g. zwave://zwave1/node/7/added.
+// Heal asks every node to find its neighbors again, one after another.
+func (zw *ZWaveBridge) triggerController(command string) error {
+	controller, err := zw.connected()
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "include":
+		err = zw.include(controller, command, funcAddNodeToNetwork)
+	case "exclude":
+		err = zw.include(controller, command, funcRemoveNodeFromNetwork)
+	case "heal":
+		err = zw.heal(controller)
+	case "stop":
+		zw.stopMode()
+	default:
+		return fmt.Errorf("z-wave bridge %s: unknown controller command %q", zw.id, command)
+	}
+	if err != nil {
+		return fmt.Errorf("z-wave bridge %s: %s: %s", zw.id, command, err)
+	}
+	return nil
+}
+
+// include starts adding or removing nodes with function, and stops it
+// when the mode ends
+func (zw *ZWaveBridge) include(controller *gozwave.Controller, name string, function byte) error {
+	m, err := zw.startMode(controller, name)
+	if err != nil {
+		return err
+	}
+	if err := send(controller, function, nodeAny|nodeHighPower); err != nil {
+		zw.clearMode(m)
+		return err
+	}
+	logger.Infof("Z-Wave bridge %s in %s mode", zw.id, name)
+	zw.eventManager.Dispatch(zw.controllerEvent("mode", name))
+
+	go func() {
+		zw.wait(m, inclusionTimeout)
+		if err := send(controller, function, nodeStop); err != nil {
+			logger.Warnf("Z-Wave bridge %s: stopping %s mode: %s", zw.id, name, err)
+		}
+		zw.endMode(controller, m)
+	}()
+	return nil
+}
+
+// heal requests a neighbor update of every node
+func (zw *ZWaveBridge) heal(controller *gozwave.Controller) error {
+	m, err := zw.startMode(controller, "heal")
+	if err != nil {
+		return err
+	}
+	logger.Infof("Z-Wave bridge %s healing %d nodes", zw.id, len(m.nodes))
+	zw.eventManager.Dispatch(zw.controllerEvent("mode", "heal"))
+
+	go func() {
+		defer zw.endMode(controller, m)
+		for n, address := range sortedAddresses(m.nodes) {
+			if n > 0 && !zw.wait(m, healInterval) {
+				return
+			}
+			if err := send(controller, funcRequestNodeNeighborUpdate, byte(address)); err != nil {
+				logger.Warnf("Z-Wave bridge %s: healing node %d: %s", zw.id, address, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// startMode makes name the mode of the controller, unless it is in
+// another one
+func (zw *ZWaveBridge) startMode(controller *gozwave.Controller, name string) (*networkMode, error) {
+	zw.mutex.Lock()
+	defer zw.mutex.Unlock()
+	if zw.mode != nil {
+		return nil, fmt.Errorf("the controller is in %s mode", zw.mode.name)
+	}
+	zw.mode = &networkMode{
+		name:  name,
+		nodes: nodeAddresses(controller),
+		end:   make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	return zw.mode, nil
+}
+
+// wait waits for d, and returns false when the mode or the bridge was
+// stopped meanwhile
+func (zw *ZWaveBridge) wait(m *networkMode, d time.Duration) bool {
+	select {
+	case <-m.end:
+		return false
+	case <-zw.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// stopMode ends the mode the controller is in, if any
+func (zw *ZWaveBridge) stopMode() {
+	zw.mutex.RLock()
+	m := zw.mode
+	zw.mutex.RUnlock()
+	if m != nil {
+		m.stop()
+	}
+}
+
+// endMode dispatches the nodes that were added or removed since the mode
+// started, followed by zwave://zwave1/controller/mode#idle
+func (zw *ZWaveBridge) endMode(controller *gozwave.Controller, m *networkMode) {
+	after := nodeAddresses(controller)
+	for _, address := range sortedAddresses(after) {
+		if !m.nodes[address] {
+			zw.eventManager.Dispatch(zw.event(address, "added", ""))
+		}
+	}
+	for _, address := range sortedAddresses(m.nodes) {
+		if !after[address] {
+			zw.eventManager.Dispatch(zw.event(address, "removed", ""))
+		}
+	}
+	logger.Infof("Z-Wave bridge %s: %s mode ended", zw.id, m.name)
+	zw.eventManager.Dispatch(zw.controllerEvent("mode", "idle"))
+	zw.clearMode(m)
+}
+
+func (zw *ZWaveBridge) clearMode(m *networkMode) {
+	zw.mutex.Lock()
+	zw.mode = nil
+	zw.mutex.Unlock()
+	close(m.done)
+}
+
+// nodeDiscovered dispatches zwave://zwave1/node/5/discovered
+func (zw *ZWaveBridge) nodeDiscovered(address int) {
+	zw.eventManager.Dispatch(zw.event(address, "discovered", ""))
+}
+
+func nodeAddresses(controller *gozwave.Controller) map[int]bool {
+	addresses := make(map[int]bool)
+	for address := range controller.Nodes.All() {
+		addresses[address] = true
+	}
+	return addresses
+}
+
+func sortedAddresses(addresses map[int]bool) []int {
+	sorted := make([]int, 0, len(addresses))
+	for address := range addresses {
+		sorted = append(sorted, address)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
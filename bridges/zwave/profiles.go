@@ -0,0 +1,138 @@
+package zwave
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cpo/events/interfaces"
+	"github.com/stampzilla/gozwave/nodes"
+)
+
+// attributes are the state of a node, formatted as event payloads
+type attributes map[string]string
+
+// profile turns the state of a node into attributes. The node is locked
+// for reading.
+type profile func(znode *nodes.Node) attributes
+
+// profiles by the "type" of a device. gozwave names the states after the
+// command class reporting them, so several names are tried.
+var profiles = map[string]profile{
+	"contact": func(znode *nodes.Node) attributes {
+		return boolAttribute(znode, "contact", "open", "closed", "door/window", "contact", "sensor")
+	},
+	"motion": func(znode *nodes.Node) attributes {
+		return boolAttribute(znode, "motion", "true", "false", "motion", "sensor")
+	},
+	"switch": func(znode *nodes.Node) attributes {
+		return boolAttribute(znode, "on", "true", "false", "on")
+	},
+	// numbers, like the level of a dimmer or the temperature of a
+	// thermostat, are reported by dispatchValues for every node
+	"dimmer": func(znode *nodes.Node) attributes {
+		return boolAttribute(znode, "on", "true", "false", "on")
+	},
+	"thermostat": func(znode *nodes.Node) attributes {
+		return attributes{}
+	},
+	"meter": func(znode *nodes.Node) attributes {
+		return attributes{}
+	},
+}
+
+func profileTypes() []string {
+	types := make([]string, 0, len(profiles))
+	for t := range profiles {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// boolAttribute returns attribute with value ifTrue or ifFalse, taken
+// from the first of keys in the state of the node
+func boolAttribute(znode *nodes.Node, attribute string, ifTrue string, ifFalse string, keys ...string) attributes {
+	for _, key := range keys {
+		if value, found := znode.StateBool[key]; found {
+			if value {
+				return attributes{attribute: ifTrue}
+			}
+			return attributes{attribute: ifFalse}
+		}
+	}
+	return attributes{}
+}
+
+// nodeUpdated dispatches the changes of a node. Nodes with a device
+// profile get one event per changed attribute, e.g.
+// zwave://zwave1/node/3/contact#open. Other nodes get one event with
+// all their flags, e.g. zwave://zwave1/node/4/state#on=true&sensor=false.
+func (zw *ZWaveBridge) nodeUpdated(znode *nodes.Node) {
+	znode.RLock()
+	defer znode.RUnlock()
+
+	zw.dispatchValues(znode)
+
+	device := zw.devices()[znode.Id]
+	nodeProfile, found := profiles[device.Type]
+	if !found {
+		zw.eventManager.Dispatch(zw.event(znode.Id, "state", genericState(znode)))
+		return
+	}
+
+	now := nodeProfile(znode)
+	then := zw.states[znode.Id]
+	names := make([]string, 0, len(now))
+	for name, value := range now {
+		if then[name] != value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		zw.eventManager.Dispatch(zw.event(znode.Id, name, now[name]))
+	}
+	zw.states[znode.Id] = now
+}
+
+func genericState(znode *nodes.Node) string {
+	params := make([]string, 0, len(znode.StateBool))
+	for k, v := range znode.StateBool {
+		params = append(params, fmt.Sprintf("%s=%t", k, v))
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// event returns an event of the node, addressed by its name when it has one
+func (zw *ZWaveBridge) event(address int, attribute string, value string) *interfaces.Event {
+	return interfaces.NewEvent("zwave", zw.id, fmt.Sprintf("node/%s/%s", zw.nodeName(address), attribute), value)
+}
+
+// controllerEvent returns an event of the controller, e.g.
+// zwave://zwave1/controller/mode#include
+func (zw *ZWaveBridge) controllerEvent(attribute string, value string) *interfaces.Event {
+	return interfaces.NewEvent("zwave", zw.id, "controller/"+attribute, value)
+}
+
+func (zw *ZWaveBridge) nodeName(address int) string {
+	if name := zw.devices()[address].Name; name != "" {
+		return name
+	}
+	return strconv.Itoa(address)
+}
+
+// nodeAddress returns the ID of the node with the given ID or name
+func (zw *ZWaveBridge) nodeAddress(node string) (int, bool) {
+	if address, err := strconv.Atoi(node); err == nil {
+		return address, true
+	}
+	for address, device := range zw.devices() {
+		if device.Name == node {
+			return address, true
+		}
+	}
+	return 0, false
+}
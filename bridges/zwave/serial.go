@@ -0,0 +1,100 @@
+package zwave
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/stampzilla/gozwave"
+	"github.com/stampzilla/gozwave/commands"
+)
+
+// Serial API functions that gozwave has no command for. They are written
+// to the connection of the controller as raw requests; the connection
+// adds the start of frame, the length and the checksum.
+const (
+	funcSendData                  = 0x13
+	funcRequestNodeNeighborUpdate = 0x48
+	funcAddNodeToNetwork          = 0x4A
+	funcRemoveNodeFromNetwork     = 0x4B
+)
+
+const (
+	// modes of funcAddNodeToNetwork and funcRemoveNodeFromNetwork
+	nodeAny       = 0x01
+	nodeStop      = 0x05
+	nodeHighPower = 0x80
+
+	// ACK, auto route and explore
+	transmitOptions = 0x25
+
+	setpointSet     = 0x01
+	setpointHeating = 0x01
+)
+
+// request is the data of a Serial API request, starting with the function
+type request []byte
+
+func (r request) Encode() []byte {
+	return r
+}
+
+var callbackID uint32
+
+// send writes a request to the controller. A callback ID is appended,
+// which the controller uses in its answers.
+func send(controller *gozwave.Controller, data ...byte) error {
+	id := byte(atomic.AddUint32(&callbackID, 1)%255 + 1)
+	return controller.Connection.Write(request(append(data, id)))
+}
+
+// sendCommand sends a command of a command class to a node
+func sendCommand(controller *gozwave.Controller, address int, command ...byte) error {
+	data := append([]byte{funcSendData, byte(address), byte(len(command))}, command...)
+	return send(controller, append(data, transmitOptions)...)
+}
+
+// setpointCommand returns the THERMOSTAT_SETPOINT SET command for the
+// heating setpoint in payload: degrees Celsius, or Fahrenheit with a
+// trailing F, e.g. 21.5 or 70F.
+func setpointCommand(payload string) ([]byte, error) {
+	scale := byte(0)
+	number := strings.TrimSpace(payload)
+	if strings.HasSuffix(strings.ToUpper(number), "F") {
+		scale = 1
+		number = number[:len(number)-1]
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("expected setpoint in degrees, got %q", payload)
+	}
+
+	// the value is sent as an integer with up to 7 decimals
+	precision := 0
+	if dot := strings.Index(number, "."); dot >= 0 {
+		precision = len(strings.TrimRight(number[dot+1:], "0"))
+	}
+	if precision > 7 {
+		precision = 7
+	}
+	product := value * math.Pow10(precision)
+	if math.Abs(product) > math.MaxInt32 {
+		return nil, fmt.Errorf("setpoint %q out of range", payload)
+	}
+	// the product is an integer but for floating point errors
+	scaled := int32(math.Floor(product + 0.5))
+	var bytes []byte
+	switch {
+	case scaled >= math.MinInt8 && scaled <= math.MaxInt8:
+		bytes = []byte{byte(scaled)}
+	case scaled >= math.MinInt16 && scaled <= math.MaxInt16:
+		bytes = []byte{byte(scaled >> 8), byte(scaled)}
+	default:
+		bytes = []byte{byte(scaled >> 24), byte(scaled >> 16), byte(scaled >> 8), byte(scaled)}
+	}
+
+	command := []byte{byte(commands.ThermostatSetpoint), setpointSet, setpointHeating, byte(precision)<<5 | scale<<3 | byte(len(bytes))}
+	return append(command, bytes...), nil
+}
@@ -0,0 +1,36 @@
+package zwave
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetpointCommand(t *testing.T) {
+	tests := []struct {
+		payload string
+		command []byte
+	}{
+		{"21", []byte{0x43, 0x01, 0x01, 0x01, 21}},
+		{"21.5", []byte{0x43, 0x01, 0x01, 0x22, 0x00, 0xd7}},
+		{"21.50", []byte{0x43, 0x01, 0x01, 0x22, 0x00, 0xd7}},
+		{"-5.5", []byte{0x43, 0x01, 0x01, 0x21, 0xc9}},
+		{"70F", []byte{0x43, 0x01, 0x01, 0x09, 70}},
+		{"1000.25", []byte{0x43, 0x01, 0x01, 0x44, 0x00, 0x01, 0x86, 0xb9}},
+	}
+	for _, test := range tests {
+		command, err := setpointCommand(test.payload)
+		if err != nil {
+			t.Errorf("%s: %s", test.payload, err)
+			continue
+		}
+		if !bytes.Equal(command, test.command) {
+			t.Errorf("%s: command % x, want % x", test.payload, command, test.command)
+		}
+	}
+
+	for _, payload := range []string{"", "warm", "F", "NaN", "1e12"} {
+		if _, err := setpointCommand(payload); err == nil {
+			t.Errorf("%q: no error", payload)
+		}
+	}
+}
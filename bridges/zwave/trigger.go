@@ -0,0 +1,107 @@
+package zwave
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cpo/events/interfaces"
+	"github.com/stampzilla/gozwave"
+	"github.com/stampzilla/gozwave/commands"
+	"github.com/stampzilla/gozwave/nodes"
+)
+
+// Trigger sends a command to a node. The path of the event is one of
+//
+//	node/<id or name>/on
+//	node/<id>/off
+//	node/<id>/level#<0-99>
+//	node/<id>/setpoint#<degrees, e.g. 21.5 or 70F>
+//	controller/include|exclude|stop|heal
+func (zw *ZWaveBridge) Trigger(ctx context.Context, event *interfaces.Event) error {
+	logger.Debugf("Trigger Z-Wave bridge %s: %s", zw.id, event)
+	parts := strings.Split(event.Path, "/")
+	if len(parts) == 2 && parts[0] == "controller" {
+		return zw.triggerController(parts[1])
+	}
+	if len(parts) != 3 || parts[0] != "node" {
+		return fmt.Errorf("z-wave bridge %s: cannot trigger %s, expected node/<id>/<command>", zw.id, event.Path)
+	}
+	znode, err := zw.node(parts[1])
+	if err != nil {
+		return err
+	}
+
+	switch parts[2] {
+	case "on", "off":
+		if !znode.HasCommand(commands.SwitchBinary) && !znode.HasCommand(commands.SwitchMultilevel) {
+			return zw.unsupported(znode, parts[2])
+		}
+		if parts[2] == "on" {
+			err = znode.On()
+		} else {
+			err = znode.Off()
+		}
+	case "level":
+		if !znode.HasCommand(commands.SwitchMultilevel) {
+			return zw.unsupported(znode, parts[2])
+		}
+		level, perr := strconv.ParseUint(event.Payload, 10, 8)
+		if perr != nil || level > 99 {
+			return fmt.Errorf("z-wave bridge %s: node %d: expected level 0-99, got %q", zw.id, znode.Id, event.Payload)
+		}
+		err = znode.Level(float64(level))
+	case "setpoint":
+		if !znode.HasCommand(commands.ThermostatSetpoint) {
+			return zw.unsupported(znode, parts[2])
+		}
+		command, perr := setpointCommand(event.Payload)
+		if perr != nil {
+			return fmt.Errorf("z-wave bridge %s: node %d: %s", zw.id, znode.Id, perr)
+		}
+		var controller *gozwave.Controller
+		if controller, err = zw.connected(); err == nil {
+			err = sendCommand(controller, znode.Id, command...)
+		}
+	default:
+		return fmt.Errorf("z-wave bridge %s: unknown command %q", zw.id, parts[2])
+	}
+	if err != nil {
+		return fmt.Errorf("z-wave bridge %s: node %d: %s: %s", zw.id, znode.Id, parts[2], err)
+	}
+	return nil
+}
+
+// connected returns the controller, or an error when the bridge is not
+// connected
+func (zw *ZWaveBridge) connected() (*gozwave.Controller, error) {
+	zw.mutex.RLock()
+	controller := zw.controller
+	zw.mutex.RUnlock()
+	if controller == nil {
+		return nil, fmt.Errorf("z-wave bridge %s is not connected", zw.id)
+	}
+	return controller, nil
+}
+
+// node returns the node with the given ID or name
+func (zw *ZWaveBridge) node(id string) (*nodes.Node, error) {
+	controller, err := zw.connected()
+	if err != nil {
+		return nil, err
+	}
+	address, found := zw.nodeAddress(id)
+	if !found {
+		return nil, fmt.Errorf("z-wave bridge %s: no node named %q", zw.id, id)
+	}
+	znode := controller.Nodes.Get(address)
+	if znode == nil {
+		return nil, fmt.Errorf("z-wave bridge %s: unknown node %d", zw.id, address)
+	}
+	return znode, nil
+}
+
+func (zw *ZWaveBridge) unsupported(znode *nodes.Node, command string) error {
+	return fmt.Errorf("z-wave bridge %s: node %d does not support %s", zw.id, znode.Id, command)
+}
@@ -0,0 +1,84 @@
+package zwave
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stampzilla/gozwave/nodes"
+)
+
+// valueNames maps the names gozwave gives numeric states to the names
+// used in events
+var valueNames = map[string]string{
+	"w":       "power",
+	"kwh":     "energy",
+	"heating": "setpoint",
+	"lux":     "luminance",
+}
+
+// units of the numeric values, attached to their events as the "unit"
+// attribute. Temperatures are left out: nodes report them in °C or °F,
+// and gozwave does not tell which.
+var units = map[string]string{
+	"luminance": "lux",
+	"humidity":  "%",
+	"level":     "%",
+	"power":     "W",
+	"energy":    "kWh",
+	"voltage":   "V",
+	"current":   "A",
+	"battery":   "%",
+}
+
+// dispatchValues dispatches the numeric values of a node, e.g.
+// zwave://zwave1/node/5/power#12.5, that changed by at least the delta
+// configured for the device since they were last dispatched. The node is
+// locked for reading.
+func (zw *ZWaveBridge) dispatchValues(znode *nodes.Node) {
+	device := zw.devices()[znode.Id]
+	last, found := zw.values[znode.Id]
+	if !found {
+		last = make(map[string]float64)
+		zw.values[znode.Id] = last
+	}
+
+	names := make([]string, 0, len(znode.StateFloat))
+	for key := range znode.StateFloat {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		value := znode.StateFloat[key]
+		name := valueName(key)
+		if previous, found := last[name]; found && !changed(previous, value, device.delta(name)) {
+			continue
+		}
+		last[name] = value
+
+		event := zw.event(znode.Id, name, strconv.FormatFloat(value, 'f', -1, 64))
+		event.Attributes["value"] = value
+		if unit, found := units[name]; found {
+			event.Attributes["unit"] = unit
+		}
+		zw.eventManager.Dispatch(event)
+	}
+}
+
+func valueName(key string) string {
+	name := strings.ToLower(key)
+	if alias, found := valueNames[name]; found {
+		return alias
+	}
+	return name
+}
+
+// changed reports whether value differs from previous by at least delta,
+// or at all when delta is 0
+func changed(previous float64, value float64, delta float64) bool {
+	if delta == 0 {
+		return value != previous
+	}
+	return math.Abs(value-previous) >= delta
+}
@@ -1,112 +1,125 @@
 package zwave
 
 import (
-	"encoding/json"
+	"fmt"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 	"sync"
-	"time"
 	"github.com/stampzilla/gozwave"
 	"github.com/stampzilla/gozwave/events"
 	log "github.com/Sirupsen/logrus"
-	"fmt"
 )
 
 var logger = log.New()
 
+func init() {
+	logger.Hooks.Add(config.RedactHook{})
+}
+
 type ZWaveBridge struct {
 	id           string
 	port         string
-	config       map[string]interface{}
-	wg           sync.WaitGroup
+	config       Config
+	stop         chan struct{}
 	eventManager interfaces.EventManager
+	mutex        sync.RWMutex
 	controller   *gozwave.Controller
+	// states are the attributes of the nodes with a device profile
+	states map[int]attributes
+	// values are the numeric values last dispatched, by node
+	values map[int]map[string]float64
+	// mode is set while the controller adds or removes nodes, or heals
+	mode *networkMode
 }
 
 func NewZWaveBridge() interfaces.Bridge {
 	return new(ZWaveBridge)
 }
 
-func (zw *ZWaveBridge) Initialize(eventManager interfaces.EventManager, config map[string]interface{}) {
-	cfgStr, _ := json.Marshal(config)
-	logger.Info("Initialize Z-Wave bridge %s with %s", zw.GetID(), cfgStr)
-	zw.port = config["port"].(string)
-	zw.id = config["name"].(string)
-	zw.config = config
+func (zw *ZWaveBridge) Initialize(eventManager interfaces.EventManager, config *config.Section) {
+	zw.config = ParseConfig(config)
+	zw.port = zw.config.Port
+	zw.id = zw.config.Name
+	logger.Infof("Initialize Z-Wave bridge %s with %s", zw.GetID(), config.JSON())
 	zw.eventManager = eventManager
+	zw.states = make(map[int]attributes)
+	zw.values = make(map[int]map[string]float64)
+	zw.stop = make(chan struct{})
 }
 
 func (zw *ZWaveBridge) GetID() string {
 	return zw.id
 }
 
-func (zw *ZWaveBridge) Connect() {
-	defer zw.connectRecovery()
-
-	var err error
-	zw.controller, err = gozwave.Connect(zw.port, "")
+// Connect opens the controller and dispatches its events until Stop is
+// called, or until the controller stops sending events.
+func (zw *ZWaveBridge) Connect() error {
+	controller, err := gozwave.Connect(zw.port, "")
 	if err != nil {
-		logger.Panicf("Panic: %s", err)
+		return err
 	}
+	zw.setController(controller)
+	defer zw.setController(nil)
 
 	logger.Debugf("Z-Wave bridge %s connected.", zw.id)
 
-	go func() {
-		for {
-			select {
-			case event := <-zw.controller.GetNextEvent():
-				logger.Println("----------------------------------------")
-				logger.Debugf("Event: %#v\n", event)
-				switch e := event.(type) {
-				case events.NodeDiscoverd:
-					znode := zw.controller.Nodes.Get(e.Address)
-					znode.RLock()
-					url := fmt.Sprintf("zwave://%s/node/%d", zw.id, znode.Id)
-					logger.Debugf("Node detected: %s", url)
-					znode.RUnlock()
-
-				case events.NodeUpdated:
-					znode := zw.controller.Nodes.Get(e.Address)
-					znode.RLock()
-					//logger.Debugf("Node: %#v\n", znode)
-
-					params := ""
-					for k, v := range znode.StateBool {
-						if params != "" {
-							params += "&"
-						}
-						params += fmt.Sprintf("%s=%t", k, v)
-					}
-					if (params != "") {
-						params = "#" + params
-					}
-					evt := fmt.Sprintf("zwave://%s/node/%d/state%s", zw.id, znode.Id, params)
-					zw.eventManager.Dispatch(evt)
-					znode.RUnlock()
+	for {
+		select {
+		case <-zw.stop:
+			logger.Debugf("Stop Z-Wave bridge %s", zw.id)
+			return nil
+		case event, ok := <-controller.GetNextEvent():
+			if !ok {
+				// e.g. the stick was unplugged
+				return fmt.Errorf("the controller on %s stopped sending events", zw.port)
+			}
+			logger.Println("----------------------------------------")
+			logger.Debugf("Event: %#v\n", event)
+			switch e := event.(type) {
+			case events.NodeDiscoverd:
+				logger.Debugf("Node detected: %d", e.Address)
+				zw.nodeDiscovered(e.Address)
+
+			case events.NodeUpdated:
+				if znode := controller.Nodes.Get(e.Address); znode != nil {
+					zw.nodeUpdated(znode)
 				}
 			}
 		}
-	}()
-
-	zw.wg = sync.WaitGroup{}
-	zw.wg.Add(1)
-	zw.wg.Wait()
+	}
+}
 
-	logger.Debugf("Stop Z-Wave bridge %s", zw.id)
+// setController makes the controller available to Trigger, or not when
+// nil
+func (zw *ZWaveBridge) setController(controller *gozwave.Controller) {
+	zw.mutex.Lock()
+	defer zw.mutex.Unlock()
+	zw.controller = controller
 }
 
-func (zw *ZWaveBridge) connectRecovery() {
-	if r := recover(); r != nil {
-		logger.Debugf("Recovering connection for Z-Wave bridge %s", zw.id)
-		time.Sleep(10 * time.Second)
-		zw.Connect()
+// Reconfigure takes over the devices of bridge, so that changed names,
+// profiles and deltas apply without opening the port again. gozwave
+// cannot close a controller, so a changed port needs a restart.
+func (zw *ZWaveBridge) Reconfigure(bridge interfaces.Bridge) bool {
+	other, ok := bridge.(*ZWaveBridge)
+	if !ok || other.port != zw.port {
+		return false
 	}
+	zw.mutex.Lock()
+	zw.config = other.config
+	zw.mutex.Unlock()
+	logger.Infof("Reconfigured Z-Wave bridge %s", zw.id)
+	return true
 }
 
-func (zw *ZWaveBridge) Stop() {
-	logger.Debugf("Setting stop signal for Z-Wave bridge %s", zw.id)
-	zw.wg.Done()
+// devices returns the configured devices by node ID
+func (zw *ZWaveBridge) devices() map[int]Device {
+	zw.mutex.RLock()
+	defer zw.mutex.RUnlock()
+	return zw.config.Devices
 }
 
-func (zw *ZWaveBridge) Trigger(uri string) {
-	logger.Debugf("(UNIMPLEMENTED) Publishing Z-Wave bridge %s: %s", zw.id, uri)
+func (zw *ZWaveBridge) Stop() {
+	logger.Debugf("Setting stop signal for Z-Wave bridge %s", zw.id)
+	close(zw.stop)
 }
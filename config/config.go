@@ -0,0 +1,307 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Error is a problem with one value of the configuration. Path is the
+// JSON path of the value, e.g. rules[3].actions[1].seconds, in File.
+type Error struct {
+	File    string
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.File != "" {
+		return e.File + ": " + e.Path + ": " + e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Errors collects every problem found while reading a configuration, so
+// they can be reported at once.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	lines := make([]string, len(errs))
+	for n, err := range errs {
+		lines[n] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Section is one JSON object of the configuration, e.g. a bridge or an
+// action. The getters return the zero value (or the default) and record
+// an error when a value is missing or has the wrong type.
+type Section struct {
+	file   string
+	path   string
+	values map[string]interface{}
+	errors *Errors
+}
+
+func NewSection(path string, values map[string]interface{}, errors *Errors) *Section {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	return &Section{path: path, values: values, errors: errors}
+}
+
+// NewFileSection returns the root section of a configuration file.
+// Errors in it and its sub sections mention the file.
+func NewFileSection(file string, values map[string]interface{}, errors *Errors) *Section {
+	s := NewSection("", values, errors)
+	s.file = file
+	return s
+}
+
+// File returns the file this section was read from, if known.
+func (s *Section) File() string {
+	return s.file
+}
+
+func (s *Section) sub(path string, values map[string]interface{}) *Section {
+	sub := NewSection(path, values, s.errors)
+	sub.file = s.file
+	return sub
+}
+
+// Path returns the JSON path of key in this section
+func (s *Section) Path(key string) string {
+	if s.path == "" {
+		return key
+	}
+	return s.path + "." + key
+}
+
+// Raw returns the values of this section as read from the file.
+func (s *Section) Raw() map[string]interface{} {
+	return s.values
+}
+
+// JSON returns the section as JSON for logging, with secrets redacted.
+func (s *Section) JSON() string {
+	str, _ := json.Marshal(Redact(s.values))
+	return string(str)
+}
+
+func (s *Section) Has(key string) bool {
+	_, found := s.values[key]
+	return found
+}
+
+// Errorf records a problem with the value of key.
+func (s *Section) Errorf(key string, format string, args ...interface{}) {
+	*s.errors = append(*s.errors, &Error{File: s.file, Path: s.Path(key), Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *Section) get(key string, required bool) (interface{}, bool) {
+	value, found := s.values[key]
+	if !found || value == nil {
+		if required {
+			s.Errorf(key, "missing")
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (s *Section) String(key string) string {
+	return s.str(key, "", true)
+}
+
+func (s *Section) OptionalString(key string, def string) string {
+	return s.str(key, def, false)
+}
+
+func (s *Section) str(key string, def string, required bool) string {
+	value, found := s.get(key, required)
+	if !found {
+		return def
+	}
+	str, ok := value.(string)
+	if !ok {
+		s.Errorf(key, "expected string, got %s", describe(value))
+		return def
+	}
+	return str
+}
+
+// OneOf returns the string value of key, which must be one of allowed.
+// Def is returned when the key is missing.
+func (s *Section) OneOf(key string, def string, allowed ...string) string {
+	str := s.str(key, def, false)
+	for _, a := range allowed {
+		if str == a {
+			return str
+		}
+	}
+	s.Errorf(key, "expected one of %s, got %q", strings.Join(allowed, ", "), str)
+	return def
+}
+
+func (s *Section) Number(key string) float64 {
+	return s.number(key, 0, true)
+}
+
+func (s *Section) OptionalNumber(key string, def float64) float64 {
+	return s.number(key, def, false)
+}
+
+func (s *Section) number(key string, def float64, required bool) float64 {
+	value, found := s.get(key, required)
+	if !found {
+		return def
+	}
+	n, ok := value.(float64)
+	if !ok {
+		s.Errorf(key, "expected number, got %s", describe(value))
+		return def
+	}
+	return n
+}
+
+func (s *Section) Int(key string) int {
+	return s.integer(key, 0, true)
+}
+
+func (s *Section) OptionalInt(key string, def int) int {
+	return s.integer(key, def, false)
+}
+
+func (s *Section) integer(key string, def int, required bool) int {
+	n := s.number(key, float64(def), required)
+	if n != math.Trunc(n) {
+		s.Errorf(key, "expected whole number, got %v", n)
+		return def
+	}
+	return int(n)
+}
+
+// AtLeast records a problem when the number under key is below min.
+// Missing values and values of the wrong type are not reported again.
+func (s *Section) AtLeast(key string, min float64) {
+	if n, ok := s.values[key].(float64); ok && n < min {
+		s.Errorf(key, "must be at least %v", min)
+	}
+}
+
+func (s *Section) Bool(key string) bool {
+	return s.boolean(key, false, true)
+}
+
+func (s *Section) OptionalBool(key string, def bool) bool {
+	return s.boolean(key, def, false)
+}
+
+func (s *Section) boolean(key string, def bool, required bool) bool {
+	value, found := s.get(key, required)
+	if !found {
+		return def
+	}
+	b, ok := value.(bool)
+	if !ok {
+		s.Errorf(key, "expected true or false, got %s", describe(value))
+		return def
+	}
+	return b
+}
+
+// Section returns the object under key. A missing key is reported and
+// an empty section is returned, so callers never get nil.
+func (s *Section) Section(key string) *Section {
+	return s.section(key, true)
+}
+
+// OptionalSection returns the object under key, or nil when it is missing.
+func (s *Section) OptionalSection(key string) *Section {
+	if !s.Has(key) {
+		return nil
+	}
+	return s.section(key, false)
+}
+
+func (s *Section) section(key string, required bool) *Section {
+	value, found := s.get(key, required)
+	values, ok := value.(map[string]interface{})
+	if found && !ok {
+		s.Errorf(key, "expected object, got %s", describe(value))
+	}
+	return s.sub(s.Path(key), values)
+}
+
+// List returns the objects in the array under key.
+func (s *Section) List(key string) []*Section {
+	return s.list(key, true)
+}
+
+func (s *Section) OptionalList(key string) []*Section {
+	return s.list(key, false)
+}
+
+func (s *Section) list(key string, required bool) []*Section {
+	value, found := s.get(key, required)
+	if !found {
+		return nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		s.Errorf(key, "expected array, got %s", describe(value))
+		return nil
+	}
+	sections := make([]*Section, 0, len(items))
+	for n, item := range items {
+		path := fmt.Sprintf("%s[%d]", s.Path(key), n)
+		values, ok := item.(map[string]interface{})
+		if !ok {
+			*s.errors = append(*s.errors, &Error{File: s.file, Path: path, Message: "expected object, got " + describe(item)})
+			continue
+		}
+		sections = append(sections, s.sub(path, values))
+	}
+	return sections
+}
+
+// OptionalStrings returns the array of strings under key, or nil when it
+// is missing.
+func (s *Section) OptionalStrings(key string) []string {
+	value, found := s.get(key, false)
+	if !found {
+		return nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		s.Errorf(key, "expected array of strings, got %s", describe(value))
+		return nil
+	}
+	strs := make([]string, 0, len(items))
+	for n, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			s.Errorf(fmt.Sprintf("%s[%d]", key, n), "expected string, got %s", describe(item))
+			continue
+		}
+		strs = append(strs, str)
+	}
+	return strs
+}
+
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
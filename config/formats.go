@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Extensions are the configuration file types that can be decoded
+var Extensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// Decode parses a configuration file, choosing the format by the
+// extension of path. Files with an unknown extension are read as JSON.
+// Whatever the format, the result contains the same types JSON decoding
+// produces: objects are map[string]interface{} and numbers float64.
+func Decode(path string, data []byte) (map[string]interface{}, error) {
+	var values interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		tomlValues := make(map[string]interface{})
+		_, err = toml.Decode(string(data), &tomlValues)
+		values = tomlValues
+	default:
+		err = json.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if values == nil {
+		// an empty YAML file
+		return make(map[string]interface{}), nil
+	}
+	object, ok := normalize(values).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an object at the top level", path)
+	}
+	return object, nil
+}
+
+// normalize converts what the YAML and TOML decoders produce into what
+// the JSON decoder produces.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[fmt.Sprint(key)] = normalize(item)
+		}
+		return object
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for n, item := range v {
+			items[n] = normalize(item)
+		}
+		return items
+	case []interface{}:
+		for n, item := range v {
+			v[n] = normalize(item)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
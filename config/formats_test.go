@@ -0,0 +1,107 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	want := map[string]interface{}{
+		"shutdownGracePeriod": float64(10),
+		"bridges": []interface{}{
+			map[string]interface{}{"name": "hue1", "type": "hue", "pollInterval": float64(250)},
+			map[string]interface{}{"name": "mqtt1", "type": "mqtt", "ratio": 0.5},
+		},
+		"eventBus": map[string]interface{}{"workers": float64(2), "overflow": "drop-oldest"},
+		"tags":     []interface{}{"a", "b"},
+	}
+	tests := []struct {
+		path string
+		data string
+	}{
+		{"config.json", `{
+			"shutdownGracePeriod": 10,
+			"bridges": [
+				{"name": "hue1", "type": "hue", "pollInterval": 250},
+				{"name": "mqtt1", "type": "mqtt", "ratio": 0.5}
+			],
+			"eventBus": {"workers": 2, "overflow": "drop-oldest"},
+			"tags": ["a", "b"]
+		}`},
+		{"config.yaml", `
+shutdownGracePeriod: 10
+bridges:
+  - name: hue1
+    type: hue
+    pollInterval: 250
+  - name: mqtt1
+    type: mqtt
+    ratio: 0.5
+eventBus:
+  workers: 2
+  overflow: drop-oldest
+tags: [a, b]
+`},
+		{"config.TOML", `
+shutdownGracePeriod = 10
+tags = ["a", "b"]
+
+[eventBus]
+workers = 2
+overflow = "drop-oldest"
+
+[[bridges]]
+name = "hue1"
+type = "hue"
+pollInterval = 250
+
+[[bridges]]
+name = "mqtt1"
+type = "mqtt"
+ratio = 0.5
+`},
+		// unknown extensions are read as JSON
+		{"config", `{"shutdownGracePeriod": 10, "bridges": [{"name": "hue1", "type": "hue", "pollInterval": 250},
+			{"name": "mqtt1", "type": "mqtt", "ratio": 0.5}], "eventBus": {"workers": 2, "overflow": "drop-oldest"},
+			"tags": ["a", "b"]}`},
+	}
+	for _, test := range tests {
+		values, err := Decode(test.path, []byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %s", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: decoded %#v, want %#v", test.path, values, want)
+		}
+	}
+}
+
+func TestDecodeYAMLKeys(t *testing.T) {
+	// keys that are not strings in YAML become strings
+	values, err := Decode("config.yml", []byte("nodes:\n  3: Kitchen\n  true: yes\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"nodes": map[string]interface{}{"3": "Kitchen", "true": true}}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("decoded %#v, want %#v", values, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if values, err := Decode("empty.yaml", nil); err != nil || len(values) != 0 {
+		t.Errorf("empty YAML file gives %v, %v, want an empty object", values, err)
+	}
+	for path, data := range map[string]string{
+		"list.yaml":   "- a\n- b\n",
+		"list.json":   `["a", "b"]`,
+		"broken.json": `{"bridges": [}`,
+		"broken.yaml": "bridges: [\n",
+		"broken.toml": "bridges = \n",
+	} {
+		if _, err := Decode(path, []byte(data)); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+	}
+}
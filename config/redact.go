@@ -0,0 +1,25 @@
+package config
+
+import log "github.com/Sirupsen/logrus"
+
+// RedactHook masks the resolved secrets in every log entry, whichever
+// logger or call site produced it. Every logger needs it added, e.g. with
+// logrus.AddHook(config.RedactHook{}) for the standard logger.
+type RedactHook struct{}
+
+func (RedactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (RedactHook) Fire(entry *log.Entry) error {
+	entry.Message = RedactString(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = RedactString(v)
+		case error:
+			entry.Data[key] = RedactString(v.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "******"
+
+// minRedactLength is the length below which resolved secrets are not
+// masked in logs: short values like "1" occur everywhere, and masking
+// them would mangle the logs and show where the secret appears.
+const minRedactLength = 4
+
+// reference matches ${env:NAME} and ${file:/path}, or an escaped $${...}
+var reference = regexp.MustCompile(`\$?\$\{(env|file):([^}]*)\}`)
+
+// keys whose values are never logged, compared in lower case
+var sensitiveKeys = []string{"password", "secret", "token", "apikey"}
+
+var secrets = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+// ResolveSecrets replaces references like ${env:MQTT_PASSWORD} and
+// ${file:/run/secrets/hue_key} in every string of values with the value
+// of the environment variable or the contents of the file. A reference
+// can be written literally as $${env:NAME}. The resolved values are
+// remembered so they can be redacted from logs.
+func ResolveSecrets(file string, values map[string]interface{}) Errors {
+	errs := Errors{}
+	resolveAll(NewFileSection(file, nil, &errs), "", values)
+	return errs
+}
+
+func resolveAll(s *Section, path string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// sorted, so problems are reported in a stable order
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			v[key] = resolveAll(s, itemPath, v[key])
+		}
+	case []interface{}:
+		for n, item := range v {
+			v[n] = resolveAll(s, fmt.Sprintf("%s[%d]", path, n), item)
+		}
+	case string:
+		return reference.ReplaceAllStringFunc(v, func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+			subs := reference.FindStringSubmatch(ref)
+			secret, err := lookup(subs[1], subs[2])
+			if err != nil {
+				s.Errorf(path, "%s", err)
+				return ""
+			}
+			remember(secret)
+			return secret
+		})
+	}
+	return value
+}
+
+func lookup(kind string, name string) (string, error) {
+	if kind == "env" {
+		value, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func remember(secret string) {
+	if len(secret) < minRedactLength {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values[secret] = true
+}
+
+// Redact returns a copy of value for logging, in which the values of
+// sensitive keys and every secret that was resolved are masked.
+func Redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if isSensitive(key) {
+				object[key] = redacted
+			} else {
+				object[key] = Redact(item)
+			}
+		}
+		return object
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for n, item := range v {
+			items[n] = Redact(item)
+		}
+		return items
+	case string:
+		return RedactString(v)
+	}
+	return value
+}
+
+// RedactString masks every resolved secret of at least minRedactLength
+// characters in s. RedactHook applies it to all logs.
+func RedactString(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for secret := range secrets.values {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
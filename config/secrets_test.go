@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestRedactHook(t *testing.T) {
+	os.Setenv("TEST_REDACT_PASSWORD", "SuperSecret123")
+	os.Setenv("TEST_REDACT_SHORT", "1")
+	values := map[string]interface{}{
+		"password": "${env:TEST_REDACT_PASSWORD}",
+		"retries":  "${env:TEST_REDACT_SHORT}",
+	}
+	if errs := ResolveSecrets("test.json", values); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	entry := &log.Entry{
+		Message: "EMailAction=&{smtp user@domain SuperSecret123} port /dev/tty.usbmodem1421",
+		Data:    log.Fields{"error": errors.New("login SuperSecret123 failed")},
+	}
+	RedactHook{}.Fire(entry)
+	if want := "EMailAction=&{smtp user@domain ******} port /dev/tty.usbmodem1421"; entry.Message != want {
+		t.Errorf("message is %q, want %q", entry.Message, want)
+	}
+	if want := "login ****** failed"; entry.Data["error"] != want {
+		t.Errorf("error is %q, want %q", entry.Data["error"], want)
+	}
+}
@@ -0,0 +1,31 @@
+package interfaces
+
+import "context"
+
+type contextKey int
+
+const (
+	eventKey contextKey = iota
+	runIDKey
+)
+
+// WithEvent returns a context carrying the event that triggered an action run.
+func WithEvent(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, eventKey, event)
+}
+
+// EventFromContext returns the triggering event, or nil if there is none.
+func EventFromContext(ctx context.Context) *Event {
+	event, _ := ctx.Value(eventKey).(*Event)
+	return event
+}
+
+// WithRunID returns a context carrying the ID of an action run, used in logging.
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey, id)
+}
+
+func RunIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey).(string)
+	return id
+}
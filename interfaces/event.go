@@ -0,0 +1,75 @@
+package interfaces
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// (?s) because payloads may contain newlines, e.g. a JSON MQTT message
+var eventURL = regexp.MustCompile("(?s)^([^:/]*)://([^/]*)/([^#]*)(#(.*))?$")
+
+// Event is something that happened on (or is sent to) a bridge.
+//
+// Its URL form is scheme://bridge/path#payload, e.g.
+// hue://hue1/sensors/10/button#4000. Rules that match on strings match
+// against this form, so converting an event to a URL and back must not
+// lose anything but the ID, timestamp and attributes. An empty payload
+// is written without the '#', and paths cannot contain a '#' (see
+// ValidPath).
+type Event struct {
+	ID         string
+	Scheme     string
+	Bridge     string
+	Path       string
+	Payload    string
+	Timestamp  time.Time
+	Attributes map[string]interface{}
+}
+
+func NewEvent(scheme string, bridge string, path string, payload string) *Event {
+	return &Event{
+		ID:         uuid.NewV4().String(),
+		Scheme:     scheme,
+		Bridge:     bridge,
+		Path:       path,
+		Payload:    payload,
+		Timestamp:  time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+// ParseEvent converts an URL like bridge://mqtt1/sonoff/cmnd/Power1#ON into an event.
+// Everything after the first '#' is the payload.
+func ParseEvent(url string) (*Event, error) {
+	subs := eventURL.FindStringSubmatch(url)
+	if subs == nil {
+		return nil, fmt.Errorf("invalid event URL %q, expected scheme://bridge/path#payload", url)
+	}
+	return NewEvent(subs[1], subs[2], subs[3], subs[5]), nil
+}
+
+// ValidPath checks that path can be part of the URL form of an event.
+// Bridges that build paths from data they receive, like MQTT topics,
+// must check them.
+func ValidPath(path string) error {
+	if strings.Contains(path, "#") {
+		return fmt.Errorf("invalid event path %q, must not contain '#'", path)
+	}
+	return nil
+}
+
+func (e *Event) URL() string {
+	url := fmt.Sprintf("%s://%s/%s", e.Scheme, e.Bridge, e.Path)
+	if e.Payload != "" {
+		url += "#" + e.Payload
+	}
+	return url
+}
+
+func (e *Event) String() string {
+	return e.URL()
+}
@@ -0,0 +1,47 @@
+package interfaces
+
+import "testing"
+
+func TestEventURLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *Event
+	}{
+		{"payload", NewEvent("hue", "hue1", "sensors/10/button", "4000")},
+		{"no payload", NewEvent("zwave", "zwave1", "node/5/discovered", "")},
+		{"hash in payload", NewEvent("mqtt", "mqtt1", "tele/color", "#ff0000")},
+		{"newlines in payload", NewEvent("mqtt", "mqtt1", "tele/sonoff/STATE", "{\n  \"POWER\": \"ON\"\n}")},
+		{"colon and slashes in payload", NewEvent("mqtt", "mqtt1", "url", "http://host/a#b")},
+		{"empty path", NewEvent("mqtt", "mqtt1", "", "x")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := ParseEvent(test.in.URL())
+			if err != nil {
+				t.Fatalf("ParseEvent(%q): %s", test.in.URL(), err)
+			}
+			if out.Scheme != test.in.Scheme || out.Bridge != test.in.Bridge || out.Path != test.in.Path || out.Payload != test.in.Payload {
+				t.Errorf("ParseEvent(%q) = %q %q %q %q, want %q %q %q %q", test.in.URL(),
+					out.Scheme, out.Bridge, out.Path, out.Payload,
+					test.in.Scheme, test.in.Bridge, test.in.Path, test.in.Payload)
+			}
+		})
+	}
+}
+
+func TestParseEventInvalid(t *testing.T) {
+	for _, url := range []string{"", "hue1/sensors/10", "hue://hue1"} {
+		if _, err := ParseEvent(url); err == nil {
+			t.Errorf("ParseEvent(%q) succeeded, want an error", url)
+		}
+	}
+}
+
+func TestValidPath(t *testing.T) {
+	if err := ValidPath("tele/sonoff/STATE"); err != nil {
+		t.Errorf("ValidPath: %s", err)
+	}
+	if err := ValidPath("tele/#/STATE"); err == nil {
+		t.Error("ValidPath accepted a path containing '#'")
+	}
+}
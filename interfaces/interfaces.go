@@ -1,30 +1,58 @@
 package interfaces
 
+import (
+	"context"
+
+	"github.com/cpo/events/config"
+)
+
 type EventManager interface {
-	Dispatch(url string)
-	Trigger(string)
-	Start()
+	Dispatch(event *Event)
+	// Trigger routes event to the bridge it addresses, see Bridge.Trigger
+	Trigger(ctx context.Context, event *Event) error
+	Start() error
 }
 
+// Publisher publishes every event, e.g. to an MQTT broker. Connect
+// behaves like the Connect of a Bridge. Publish is called by the workers
+// of the event bus, so it should hand the event off quickly instead of
+// waiting for the broker.
 type Publisher interface {
-	Connect()
-	Publish(url string)
+	Connect() error
+	Publish(event *Event)
+	Stop()
 }
 
+// Action is one step of a rule. Run must return as soon as possible once
+// ctx is done; ctx also carries the triggering event and the run ID.
 type Action interface {
-	Run(EventManager, string)
+	Run(ctx context.Context, eventManager EventManager) error
 }
 
 type Rule interface {
-	Initialize(config map[string]interface{})
-	Matches(url string) bool
+	Initialize(config *config.Section)
+	Matches(event *Event) bool
 	GetActions() []Action
 }
 
 type Bridge interface {
-	Initialize(eventManager EventManager, config map[string]interface{})
-	Connect()
+	Initialize(eventManager EventManager, config *config.Section)
+	// Connect connects the bridge and runs until Stop is called, then it
+	// returns nil. When the connection fails it cleans up and returns the
+	// error; the event manager calls it again after a while.
+	Connect() error
 	GetID() string
 	Stop()
-	Trigger(string)
+	// Trigger performs the command addressed by the path of event, e.g.
+	// bridge://hue1/lights/3/on, and reports when it could not. It gives
+	// up when ctx is done, e.g. when the rule is restarted.
+	Trigger(ctx context.Context, event *Event) error
+}
+
+// Reconfigurer is a bridge that can take a changed configuration without
+// reconnecting. Reconfigure is given a new bridge of the same type,
+// initialized with the changed configuration, and returns false when the
+// change needs a restart after all.
+type Reconfigurer interface {
+	Reconfigure(bridge Bridge) bool
 }
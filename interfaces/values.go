@@ -0,0 +1,54 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Attribute returns the attribute at path, where dots separate the keys
+// of nested objects and the indexes of arrays, e.g. ENERGY.Power.
+func (e *Event) Attribute(path string) (interface{}, bool) {
+	return Lookup(e.Attributes, path)
+}
+
+// Lookup returns the value at path in values, as decoded from JSON.
+func Lookup(values map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = values
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, found := v[key]
+			if !found {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(v) {
+				return nil, false
+			}
+			value = v[n]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// FormatValue formats a value decoded from JSON as an event payload.
+// Objects and arrays are written as JSON.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	str, _ := json.Marshal(value)
+	return string(str)
+}
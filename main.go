@@ -1,14 +1,27 @@
 package main
 
 import (
+	"fmt"
+	"github.com/cpo/events/bridges/hue"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/manager"
 	logger "github.com/Sirupsen/logrus"
 	"flag"
+	"os"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "pair-hue" {
+		pairHue(os.Args[2:])
+		return
+	}
+
+	logger.AddHook(config.RedactHook{})
 	logger.Info("Starting...")
 	logLevel := flag.String("loglevel", "debug", "Set loglevel (debug|info|warn|error)")
+	configPath := flag.String("config", "config.json", "Configuration file, or directory of configuration files")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration and exit")
 	flag.Parse()
 
 	formatter := new(logger.TextFormatter)
@@ -18,9 +31,49 @@ func main() {
 	level, _ := logger.ParseLevel(*logLevel)
 	logger.SetLevel(level)
 
-	var evtMgr = manager.New()
+	if *checkConfig {
+		if err := manager.CheckConfig(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK")
+		os.Exit(0)
+	}
+
+	var evtMgr = manager.New(*configPath)
 
 	logger.Info("Startup")
 
-	evtMgr.Start()
+	if err := evtMgr.Start(); err != nil {
+		logger.Errorf("%s", err)
+		if err == manager.ErrShutdownTimeout {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// pairHue obtains the apiKey of a Hue bridge: events pair-hue [-host address]
+func pairHue(args []string) {
+	flags := flag.NewFlagSet("pair-hue", flag.ExitOnError)
+	host := flags.String("host", "", "Address of the HUE bridge, discovered when empty")
+	bridgeID := flags.String("bridgeId", "", "ID of the HUE bridge to discover, when there are several")
+	timeout := flags.Duration("timeout", 30*time.Second, "How long to wait for the link button")
+	flags.Parse(args)
+
+	if *host == "" {
+		var err error
+		if *host, err = hue.Locate(*bridgeID); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Press the link button on the HUE bridge at %s\n", *host)
+	hostname, _ := os.Hostname()
+	apiKey, err := hue.Pair(*host, "events#"+hostname, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Pairing failed: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(apiKey)
 }
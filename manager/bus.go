@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
+)
+
+// What to do when a worker queue is full
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+)
+
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 100
+)
+
+// eventBus hands dispatched events to a fixed number of workers. Every
+// worker has its own bounded queue and all events of one bridge end up
+// on the same worker, so they are handled in the order they were dispatched.
+type eventBus struct {
+	queues   []chan *interfaces.Event
+	overflow string
+	handler  func(*interfaces.Event)
+	dropped  uint64
+	wg       sync.WaitGroup
+
+	// closed is protected by mutex, publishers hold a read lock while queueing
+	mutex  sync.RWMutex
+	closed bool
+}
+
+type busConfig struct {
+	workers   int
+	queueSize int
+	overflow  string
+}
+
+// parseBusConfig reads the "eventBus" section, which may be nil.
+func parseBusConfig(s *config.Section) busConfig {
+	if s == nil {
+		return busConfig{defaultWorkers, defaultQueueSize, OverflowBlock}
+	}
+	c := busConfig{
+		workers:   s.OptionalInt("workers", defaultWorkers),
+		queueSize: s.OptionalInt("queueSize", defaultQueueSize),
+		overflow:  s.OneOf("overflow", OverflowBlock, OverflowBlock, OverflowDropOldest, OverflowDropNewest),
+	}
+	s.AtLeast("workers", 1)
+	s.AtLeast("queueSize", 1)
+	return c
+}
+
+func newEventBus(config busConfig, handler func(*interfaces.Event)) *eventBus {
+	bus := &eventBus{
+		queues:   make([]chan *interfaces.Event, config.workers),
+		overflow: config.overflow,
+		handler:  handler,
+	}
+	for n := range bus.queues {
+		bus.queues[n] = make(chan *interfaces.Event, config.queueSize)
+	}
+	logger.Debugf("Event bus: %d workers, queue size %d, overflow policy %s", config.workers, config.queueSize, config.overflow)
+	return bus
+}
+
+func (bus *eventBus) start() {
+	for _, queue := range bus.queues {
+		bus.wg.Add(1)
+		go bus.work(queue)
+	}
+}
+
+// stop refuses new events and returns when the workers have handled
+// all events that were already queued.
+func (bus *eventBus) stop() {
+	bus.mutex.Lock()
+	if !bus.closed {
+		bus.closed = true
+		for _, queue := range bus.queues {
+			close(queue)
+		}
+	}
+	bus.mutex.Unlock()
+	bus.wg.Wait()
+}
+
+func (bus *eventBus) work(queue chan *interfaces.Event) {
+	defer bus.wg.Done()
+	for event := range queue {
+		bus.handler(event)
+	}
+}
+
+// publish queues the event on the worker of its source bridge, applying
+// the overflow policy when that queue is full.
+func (bus *eventBus) publish(event *interfaces.Event) {
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+	if bus.closed {
+		logger.Debugf("Event bus stopped, ignoring %s", event)
+		return
+	}
+
+	queue := bus.queueFor(event)
+	switch bus.overflow {
+	case OverflowDropNewest:
+		select {
+		case queue <- event:
+		default:
+			bus.drop(event)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case queue <- event:
+				return
+			default:
+			}
+			select {
+			case oldest := <-queue:
+				bus.drop(oldest)
+			default:
+			}
+		}
+	default:
+		queue <- event
+	}
+}
+
+func (bus *eventBus) queueFor(event *interfaces.Event) chan *interfaces.Event {
+	h := fnv.New32a()
+	h.Write([]byte(event.Scheme + "://" + event.Bridge))
+	return bus.queues[h.Sum32()%uint32(len(bus.queues))]
+}
+
+func (bus *eventBus) drop(event *interfaces.Event) {
+	atomic.AddUint64(&bus.dropped, 1)
+	logger.Debugf("Event queue full, dropped %s", event)
+}
+
+// Dropped returns the number of events dropped because of a full queue.
+func (bus *eventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&bus.dropped)
+}
+
+// Queued returns the number of events waiting to be handled.
+func (bus *eventBus) Queued() int {
+	queued := 0
+	for _, queue := range bus.queues {
+		queued += len(queue)
+	}
+	return queued
+}
@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cpo/events/interfaces"
+)
+
+// recorder collects the payloads of the handled events
+type recorder struct {
+	mutex    sync.Mutex
+	payloads []string
+}
+
+func (r *recorder) handle(event *interfaces.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.payloads = append(r.payloads, event.Payload)
+}
+
+func TestBusOverflow(t *testing.T) {
+	tests := []struct {
+		overflow string
+		handled  []string
+	}{
+		{OverflowDropNewest, []string{"1", "2"}},
+		{OverflowDropOldest, []string{"4", "5"}},
+	}
+	for _, test := range tests {
+		t.Run(test.overflow, func(t *testing.T) {
+			r := &recorder{}
+			bus := newEventBus(busConfig{workers: 1, queueSize: 2, overflow: test.overflow}, r.handle)
+			// the workers are not started yet, so the queue fills up
+			for n := 1; n <= 5; n++ {
+				bus.publish(interfaces.NewEvent("hue", "hue1", "sensors/1/button", fmt.Sprint(n)))
+			}
+			if bus.Queued() != 2 || bus.Dropped() != 3 {
+				t.Errorf("queued %d and dropped %d events, want 2 and 3", bus.Queued(), bus.Dropped())
+			}
+			bus.start()
+			bus.stop()
+			if !reflect.DeepEqual(r.payloads, test.handled) {
+				t.Errorf("handled %v, want %v", r.payloads, test.handled)
+			}
+		})
+	}
+}
+
+func TestBusBlockKeepsOrder(t *testing.T) {
+	r := &recorder{}
+	bus := newEventBus(busConfig{workers: 4, queueSize: 1, overflow: OverflowBlock}, r.handle)
+	bus.start()
+	want := []string{}
+	for n := 0; n < 100; n++ {
+		want = append(want, fmt.Sprint(n))
+		bus.publish(interfaces.NewEvent("mqtt", "mqtt1", "topic", fmt.Sprint(n)))
+	}
+	bus.stop()
+	if !reflect.DeepEqual(r.payloads, want) {
+		t.Errorf("handled %v, want the events of one bridge in order", r.payloads)
+	}
+	if bus.Dropped() != 0 {
+		t.Errorf("dropped %d events", bus.Dropped())
+	}
+}
+
+func TestBusStopped(t *testing.T) {
+	r := &recorder{}
+	bus := newEventBus(busConfig{workers: 2, queueSize: 10, overflow: OverflowBlock}, r.handle)
+	bus.start()
+	bus.publish(interfaces.NewEvent("hue", "hue1", "lights/1/on", "true"))
+	bus.stop()
+	// ignored, not blocking or panicking on the closed queues
+	bus.publish(interfaces.NewEvent("hue", "hue1", "lights/1/on", "false"))
+	if !reflect.DeepEqual(r.payloads, []string{"true"}) {
+		t.Errorf("handled %v, want the event published before stop only", r.payloads)
+	}
+}
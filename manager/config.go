@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"reflect"
+	"time"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/bridges"
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
+	"github.com/cpo/events/publishers"
+	"github.com/cpo/events/rules"
+)
+
+// configuration holds everything built from one version of the
+// configuration files. Bridges and publisher are only built when their
+// configuration differs from the running one.
+type configuration struct {
+	gracePeriod     time.Duration
+	bus             busConfig
+	retry           retryPolicy
+	rules           []*ruleRunner
+	bridgeConfigs   map[string]map[string]interface{}
+	newBridges      map[string]interfaces.Bridge
+	publisherConfig map[string]interface{}
+	newPublisher    interfaces.Publisher
+}
+
+// build instantiates the rules and every bridge and publisher whose
+// configuration changed. Nothing is started, so a configuration that
+// fails to build leaves the running one untouched. All problems found
+// are returned at once as config.Errors.
+//
+// Bridges and rules of all files are merged. The other settings may
+// only be made in one of the files.
+func (em *EventManagerImpl) build(files []configFile) (*configuration, error) {
+	errs := config.Errors{}
+
+	cfg := &configuration{
+		gracePeriod:   defaultGracePeriod,
+		bus:           parseBusConfig(nil),
+		retry:         parseRetryPolicy(nil),
+		bridgeConfigs: make(map[string]map[string]interface{}),
+		newBridges:    make(map[string]interfaces.Bridge),
+	}
+	definedIn := make(map[string]string)
+	bridgeFiles := make(map[string]string)
+
+	for _, file := range files {
+		root := config.NewFileSection(file.path, file.values, &errs)
+		if root.Has("include") && !file.main {
+			root.Errorf("include", "only allowed in the main configuration file")
+		}
+		for _, key := range []string{"shutdownGracePeriod", "eventBus", "reconnect", "publisher"} {
+			if other, found := definedIn[key]; found && root.Has(key) {
+				root.Errorf(key, "already defined in %s", other)
+			} else if root.Has(key) {
+				definedIn[key] = file.path
+			}
+		}
+
+		if definedIn["shutdownGracePeriod"] == file.path {
+			cfg.gracePeriod = time.Duration(root.Number("shutdownGracePeriod") * float64(time.Second))
+		}
+		if definedIn["eventBus"] == file.path {
+			cfg.bus = parseBusConfig(root.Section("eventBus"))
+		}
+		if definedIn["reconnect"] == file.path {
+			cfg.retry = parseRetryPolicy(root.Section("reconnect"))
+		}
+		if definedIn["publisher"] == file.path {
+			em.buildPublisher(cfg, root.Section("publisher"))
+		}
+
+		logger.Debugf("Initializing bridges of %s", file.path)
+		for _, bridgeConfig := range root.OptionalList("bridges") {
+			name := bridgeConfig.String("name")
+			if other, found := bridgeFiles[name]; found {
+				bridgeConfig.Errorf("name", "duplicate bridge name %q, also defined in %s", name, other)
+				continue
+			}
+			bridgeFiles[name] = file.path
+			em.buildBridge(cfg, name, bridgeConfig)
+		}
+
+		logger.Debugf("Initializing rules of %s", file.path)
+		for _, ruleConfig := range root.OptionalList("rules") {
+			em.buildRule(cfg, ruleConfig)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+func (em *EventManagerImpl) buildPublisher(cfg *configuration, pubConfig *config.Section) {
+	cfg.publisherConfig = pubConfig.Raw()
+	if reflect.DeepEqual(cfg.publisherConfig, em.publisherConfig) {
+		return
+	}
+	publisherType := pubConfig.String("type")
+	if publisherFactory, found := publishers.PublisherFactories[publisherType]; found {
+		cfg.newPublisher = publisherFactory(em, pubConfig)
+	} else if publisherType != "" {
+		pubConfig.Errorf("type", "unknown publisher type %q", publisherType)
+	}
+}
+
+func (em *EventManagerImpl) buildBridge(cfg *configuration, name string, bridgeConfig *config.Section) {
+	cfg.bridgeConfigs[name] = bridgeConfig.Raw()
+	if reflect.DeepEqual(bridgeConfig.Raw(), em.bridgeConfigs[name]) {
+		return
+	}
+	bridgeType := bridgeConfig.String("type")
+	logger.Debugf("Instantiating bridge type %s", bridgeType)
+	bridgeFactory, found := bridges.BridgeFactories[bridgeType]
+	if !found {
+		if bridgeType != "" {
+			bridgeConfig.Errorf("type", "unknown bridge type %q", bridgeType)
+		}
+		return
+	}
+	newBridge := bridgeFactory()
+	newBridge.Initialize(em, bridgeConfig)
+	cfg.newBridges[name] = newBridge
+}
+
+func (em *EventManagerImpl) buildRule(cfg *configuration, ruleConfig *config.Section) {
+	ruleType := ruleConfig.String("type")
+	logger.Debugf("Instantiating rule type %s", ruleType)
+	ruleFactory, found := rules.RuleFactories[ruleType]
+	if !found {
+		if ruleType != "" {
+			ruleConfig.Errorf("type", "unknown rule type %q", ruleType)
+		}
+		return
+	}
+	newRule := ruleFactory(ruleConfig)
+	cfg.rules = append(cfg.rules, newRuleRunner(em, newRule, ruleConfig))
+	logger.Debugf("Adding %s rule", ruleType)
+}
+
+// CheckConfig reads and validates the configuration without starting
+// anything.
+func CheckConfig(path string) error {
+	em := new(EventManagerImpl).initialize()
+	files, _, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	_, err = em.build(files)
+	return err
+}
+
+func logConfigError(err error) {
+	if errs, ok := err.(config.Errors); ok {
+		for _, e := range errs {
+			logger.Errorf("  %s", e)
+		}
+		return
+	}
+	logger.Errorf("  %s", err)
+}
+
+// apply swaps in the rules of cfg and restarts the bridges and the
+// publisher that were changed, unless the bridge can take the change
+// without a restart. Bridges that are no longer configured are stopped.
+func (em *EventManagerImpl) apply(cfg *configuration) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	em.gracePeriod = cfg.gracePeriod
+	em.rules = cfg.rules
+	// applies to bridges and publishers started from now on
+	em.retry = cfg.retry
+
+	for name := range em.bridges {
+		_, configured := cfg.bridgeConfigs[name]
+		_, changed := cfg.newBridges[name]
+		if configured && changed {
+			if r, ok := em.bridges[name].(interfaces.Reconfigurer); ok && r.Reconfigure(cfg.newBridges[name]) {
+				delete(cfg.newBridges, name)
+				continue
+			}
+		}
+		if !configured || changed {
+			logger.Infof("Stopping bridge %s", name)
+			em.stopBridge(name)
+		}
+	}
+	for name, bridge := range cfg.newBridges {
+		logger.Infof("Starting bridge %s", name)
+		em.bridges[name] = bridge
+		em.supervisors[name] = em.supervise("Bridge "+name, bridge.Connect, em.retry)
+	}
+	em.bridgeConfigs = cfg.bridgeConfigs
+
+	if em.publisher != nil && (cfg.publisherConfig == nil || cfg.newPublisher != nil) {
+		logger.Infof("Stopping publisher")
+		em.stopPublisher()
+	}
+	if cfg.newPublisher != nil {
+		logger.Infof("Starting publisher")
+		em.publisher = cfg.newPublisher
+		em.publisherSuper = em.supervise("Publisher", em.publisher.Connect, em.retry)
+	}
+	em.publisherConfig = cfg.publisherConfig
+
+	logger.Debugf(" === Bridges: %d, Rules: %d ===", len(em.bridges), len(em.rules))
+}
+
+// stopBridge stops a bridge and its retries. The mutex must be locked.
+func (em *EventManagerImpl) stopBridge(name string) {
+	em.supervisors[name].halt()
+	em.bridges[name].Stop()
+	delete(em.supervisors, name)
+	delete(em.bridges, name)
+}
+
+// stopPublisher stops the publisher and its retries. The mutex must be
+// locked.
+func (em *EventManagerImpl) stopPublisher() {
+	em.publisherSuper.halt()
+	em.publisher.Stop()
+	em.publisher, em.publisherSuper = nil, nil
+}
+
+// reload re-reads the configuration. An invalid configuration is
+// logged and the running configuration is kept.
+func (em *EventManagerImpl) reload() {
+	logger.Infof("Reloading configuration %s", em.configPath)
+	files, watch, err := loadConfig(em.configPath)
+	em.setWatch(watch)
+	if err != nil {
+		logger.Errorf("Keeping current configuration: %s", err)
+		return
+	}
+	cfg, err := em.build(files)
+	if err != nil {
+		logger.Errorf("Keeping current configuration, %s is invalid:", em.configPath)
+		logConfigError(err)
+		return
+	}
+	em.apply(cfg)
+	logger.Infof("Configuration %s reloaded", em.configPath)
+}
+
+func (em *EventManagerImpl) setWatch(paths []string) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.watch = paths
+}
@@ -1,169 +1,232 @@
 package manager
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/cpo/events/bridges"
+	logger "github.com/Sirupsen/logrus"
 	"github.com/cpo/events/interfaces"
-	"github.com/cpo/events/rules"
 	"github.com/satori/go.uuid"
-	"io/ioutil"
-	logger "github.com/Sirupsen/logrus"
 	"os"
 	"os/signal"
-	"regexp"
 	"runtime"
+	"sync"
+	"syscall"
 	"time"
-	"github.com/cpo/events/publishers"
 )
 
+const defaultGracePeriod = 10 * time.Second
+
+// how long shutdown waits for bridges and the publisher to disconnect
+const disconnectTimeout = 5 * time.Second
+
+// ErrShutdownTimeout is returned by Start when running actions had to be
+// cancelled because they did not finish within the grace period.
+var ErrShutdownTimeout = errors.New("actions still running after grace period, cancelled")
+
 type EventManagerImpl struct {
-	id        string
-	bridges   map[string]interfaces.Bridge
-	rules     []interfaces.Rule
-	publisher interfaces.Publisher
+	id         string
+	configPath string
+	runs       sync.WaitGroup
+	// connections counts the running supervisors
+	connections sync.WaitGroup
+	bus         *eventBus
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	// protects the fields below, they are replaced on reload
+	mutex           sync.RWMutex
+	bridges         map[string]interfaces.Bridge
+	supervisors     map[string]*supervisor
+	bridgeConfigs   map[string]map[string]interface{}
+	rules           []*ruleRunner
+	publisher       interfaces.Publisher
+	publisherSuper  *supervisor
+	publisherConfig map[string]interface{}
+	retry           retryPolicy
+	gracePeriod     time.Duration
+	watch           []string
 }
 
-func New() interfaces.EventManager {
-	return new(EventManagerImpl).initialize()
+// New returns an event manager that reads its configuration from
+// configPath, a file or a directory.
+func New(configPath string) interfaces.EventManager {
+	em := new(EventManagerImpl).initialize()
+	em.configPath = configPath
+	return em
 }
 
-func (em *EventManagerImpl) initialize() interfaces.EventManager {
+func (em *EventManagerImpl) initialize() *EventManagerImpl {
 	em.id = uuid.NewV4().String()
 	em.bridges = make(map[string]interfaces.Bridge)
+	em.supervisors = make(map[string]*supervisor)
+	em.ctx, em.cancel = context.WithCancel(context.Background())
+	em.gracePeriod = defaultGracePeriod
 	logger.Debugf("Initializing EventManager %s", em.id)
 	return em
 }
 
-func (em *EventManagerImpl) AddBridge(bridge interfaces.Bridge, bridgeConfig map[string]interface{}) {
-	bridge.Initialize(em, bridgeConfig)
-	go bridge.Connect()
-	logger.Debugf("Adding bridge %s", bridge.GetID())
-	em.bridges[bridge.GetID()] = bridge
-	logger.Debugf("#bridges: %d", len(em.bridges))
+// Dispatch queues the event on the event bus. The rules are matched by
+// one of the bus workers.
+func (em *EventManagerImpl) Dispatch(event *interfaces.Event) {
+	em.bus.publish(event)
 }
 
-func (em *EventManagerImpl) Dispatch(url string) {
-	if em.publisher != nil {
-		logger.Debugf("Publishing event %s", url)
-		go em.publisher.Publish(url)
+func (em *EventManagerImpl) handle(event *interfaces.Event) {
+	em.mutex.RLock()
+	publisher, rules := em.publisher, em.rules
+	em.mutex.RUnlock()
+
+	// publishing in the worker keeps the events of a bridge in order, and
+	// the bus drains its queues before the publisher is stopped
+	if publisher != nil {
+		logger.Debugf("Publishing event %s", event)
+		publisher.Publish(event)
 	}
 
-	logger.Debugf("Dispatching event %s", url)
+	logger.Debugf("Dispatching event %s", event)
 	matches := 0
-	for ruleN, rule := range em.rules {
-		if rule.Matches(url) {
+	for ruleN, runner := range rules {
+		if runner.rule.Matches(event) {
 			matches++
-			logger.Infof("Rule %d matches. Execute %d actions", ruleN, len(rule.GetActions()))
-			myId := uuid.NewV4().String()
-			for _, action := range rule.GetActions() {
-				acJson, _ := json.Marshal(action)
-				logger.Infof(" [%s] running %T action %s", myId, action, acJson)
-				action.Run(em, myId)
-			}
+			logger.Infof("Rule %d (%s) matches. Execute %d actions", ruleN, runner.mode, len(runner.rule.GetActions()))
+			runner.trigger(event)
 		}
 	}
 	logger.Debugf("Matched %d rules", matches)
 }
 
-func (em *EventManagerImpl) run() {
+func (em *EventManagerImpl) run() error {
 	go func() {
 		for true {
 			time.Sleep(30 * time.Second)
 			ms := runtime.MemStats{}
 			runtime.ReadMemStats(&ms)
 			fmtTime := time.Unix(int64(ms.LastGC)/1000/1000/1000, 0).Local().Format("Mon 02-01-2006 15:04:05")
-			logger.Debugf(" ==> goroutines: %d, heap: %d, lastgc: %s", runtime.NumGoroutine(), ms.HeapAlloc, fmtTime)
+			logger.Debugf(" ==> goroutines: %d, heap: %d, lastgc: %s, queued events: %d, dropped events: %d",
+				runtime.NumGoroutine(), ms.HeapAlloc, fmtTime, em.bus.Queued(), em.bus.Dropped())
 		}
 	}()
+
+	reload := make(chan bool, 1)
+	go em.watchConfig(reload)
+
 	logger.Info("Running EventManager")
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(c)
+
+	for {
+		// Block until a signal or a configuration change is received.
+		select {
+		case <-reload:
+			em.reload()
+		case s := <-c:
+			logger.Infof("Got signal: %s", s)
+			if s == syscall.SIGHUP {
+				em.reload()
+				continue
+			}
+			return em.shutdown()
+		}
+	}
+}
 
-	// Block until a signal is received.
-	s := <-c
-	fmt.Println("Got signal:", s)
+// shutdown stops accepting events, handles the events that are still
+// queued, gives running actions the grace period to finish and then
+// stops the bridges and the publisher.
+func (em *EventManagerImpl) shutdown() error {
+	logger.Info("EventManager handling queued events...")
+	em.bus.stop()
+
+	em.mutex.RLock()
+	gracePeriod := em.gracePeriod
+	em.mutex.RUnlock()
+
+	var result error
+	logger.Infof("EventManager waiting at most %s for running actions...", gracePeriod)
+	if !waitTimeout(&em.runs, gracePeriod) {
+		logger.Warn("EventManager cancelling running actions")
+		result = ErrShutdownTimeout
+	}
+	em.cancel()
+	waitTimeout(&em.runs, time.Second)
 
+	em.mutex.Lock()
 	logger.Info("EventManager stopping all bridges...")
-	for _, bridge := range em.bridges {
-		bridge.Stop()
+	for name := range em.bridges {
+		em.stopBridge(name)
 	}
+	if em.publisher != nil {
+		logger.Info("EventManager stopping publisher...")
+		em.stopPublisher()
+	}
+	em.mutex.Unlock()
+
+	if !waitTimeout(&em.connections, disconnectTimeout) {
+		logger.Warn("EventManager not all connections were closed")
+	}
+	logger.Info("EventManager stopped")
+	return result
 }
 
-func (em *EventManagerImpl) AddRule(rule interfaces.Rule, i map[string]interface{}) {
-	em.rules = append(em.rules, rule)
-	logger.Debugf("Adding rule %s", rule)
+// waitTimeout waits for wg, returns false when that took longer than timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
-func (em *EventManagerImpl) Trigger(url string) {
-	logger.Debugf("Triggering %s", url)
-	re, _ := regexp.Compile("^([^:]*)://([^/]*)/(.*)")
-	subs := re.FindStringSubmatch(url)
-	logger.Debugf("Sub matches: %s", subs)
-	switch subs[1] {
+func (em *EventManagerImpl) Trigger(ctx context.Context, event *interfaces.Event) error {
+	logger.Debugf("Triggering %s", event)
+	switch event.Scheme {
 	case "bridge":
-		logger.Debugf("Routing to %s bridge %s", subs[1], subs[2])
-		em.triggerBridge(subs[2], subs[3])
+		logger.Debugf("Routing to %s bridge %s", event.Scheme, event.Bridge)
+		return em.triggerBridge(ctx, event)
 	}
+	return fmt.Errorf("cannot trigger %s: unknown scheme %s", event, event.Scheme)
 }
 
-func (em *EventManagerImpl) triggerBridge(name string, uri string) {
-	logger.Debugf("triggering bridge %s uri %s", name, uri)
-	bridge, found := em.bridges[name]
-	if found {
-		logger.Debugf("Found bridge %s", name)
-		bridge.Trigger(uri)
-	} else {
-		logger.Panicf("Bridge %s not found. Remaining URI %s", name, uri)
+func (em *EventManagerImpl) triggerBridge(ctx context.Context, event *interfaces.Event) error {
+	logger.Debugf("triggering bridge %s path %s", event.Bridge, event.Path)
+	em.mutex.RLock()
+	bridge, found := em.bridges[event.Bridge]
+	em.mutex.RUnlock()
+	if !found {
+		return fmt.Errorf("bridge %s not found. Remaining URI %s", event.Bridge, event.Path)
 	}
+	logger.Debugf("Found bridge %s", event.Bridge)
+	return bridge.Trigger(ctx, event)
 }
 
-func (em *EventManagerImpl) Start() {
+// Start reads the configuration, starts all bridges and runs until
+// SIGINT or SIGTERM is received. SIGHUP or a change of the configuration
+// file reloads the configuration.
+func (em *EventManagerImpl) Start() error {
 	logger.Debugf("Reading configuration")
-	config, err := ioutil.ReadFile("config.json")
+	files, watch, err := loadConfig(em.configPath)
+	em.setWatch(watch)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	jsonObject := make(map[string]interface{})
-	err = json.Unmarshal(config, &jsonObject)
+	cfg, err := em.build(files)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	if pubConfig := jsonObject["publisher"].(map[string]interface{}); pubConfig != nil {
-		em.publisher = publishers.PublisherFactories[pubConfig["type"].(string)](em, pubConfig)
-		go em.publisher.Connect()
-	}
+	em.bus = newEventBus(cfg.bus, em.handle)
+	em.bus.start()
 
-	logger.Debugf("Initializing bridges")
-	for _, bridgeConfig := range jsonObject["bridges"].([]interface{}) {
-		bridgeType := bridgeConfig.(map[string]interface{})["type"].(string)
-		logger.Debugf("Instantiating bridge type %s", bridgeType)
-		bridgeFactory, found := bridges.BridgeFactories[bridgeType]
-		if found {
-			newBridge := bridgeFactory()
-			em.AddBridge(newBridge, bridgeConfig.(map[string]interface{}))
-		} else {
-			logger.Fatalf("Error while instantiating bridge: %b", err)
-		}
-	}
+	em.apply(cfg)
 
-	logger.Debugf("Initializing rules")
-	for _, ruleConfig := range jsonObject["rules"].([]interface{}) {
-		ruleType := ruleConfig.(map[string]interface{})["type"].(string)
-		logger.Debugf("Instantiating rule type %s", ruleType)
-		ruleFactory, found := rules.RuleFactories[ruleType]
-		if found {
-			newRule := ruleFactory(ruleConfig.(map[string]interface{}))
-			em.AddRule(newRule, ruleConfig.(map[string]interface{}))
-		} else {
-			logger.Fatalf("Error while instantiating bridge: %b", err)
-		}
-	}
-
-	logger.Debugf(" === Bridges: %d, Rules: %d ===", len(em.bridges), len(em.rules))
 	logger.Debugf("Entering main loop")
-	em.run()
-
+	return em.run()
 }
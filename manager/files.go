@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/config"
+)
+
+const configPollInterval = 5 * time.Second
+
+// configFile is one parsed configuration file
+type configFile struct {
+	path   string
+	values map[string]interface{}
+	main   bool
+}
+
+// loadConfig reads the configuration at path. Path is either a file,
+// which may name more files or directories in "include", or a directory
+// of which every configuration file is read in alphabetical order.
+// It also returns the files and directories to watch for changes. When
+// reading fails, these are all paths read so far, including the broken
+// one, so that fixing it triggers a reload.
+func loadConfig(path string) ([]configFile, []string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, []string{path}, err
+	}
+	if info.IsDir() {
+		files, read, err := readConfigDir(path)
+		return files, append([]string{path}, read...), err
+	}
+
+	watch := []string{path}
+	values, err := readConfig(path)
+	if err != nil {
+		return nil, watch, err
+	}
+	files := []configFile{{path: path, values: values, main: true}}
+
+	includes, err := includePatterns(values)
+	if err != nil {
+		return nil, watch, fmt.Errorf("%s: include: %s", path, err)
+	}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if info, err := os.Stat(include); err == nil && info.IsDir() {
+			included, read, err := readConfigDir(include)
+			watch = append(append(watch, include), read...)
+			if err != nil {
+				return nil, watch, err
+			}
+			files = append(files, included...)
+			continue
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return nil, watch, fmt.Errorf("%s: include: %s", path, err)
+		}
+		// the directory shows files being added or removed
+		watch = append(watch, filepath.Dir(include))
+		if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+			return nil, watch, fmt.Errorf("%s: include: %s does not exist", path, include)
+		}
+		for _, match := range matches {
+			watch = append(watch, match)
+			values, err := readConfig(match)
+			if err != nil {
+				return nil, watch, err
+			}
+			files = append(files, configFile{path: match, values: values})
+		}
+	}
+	return files, watch, nil
+}
+
+// includePatterns returns the "include" value, a string or an array of
+// strings, of the main configuration file.
+func includePatterns(values map[string]interface{}) ([]string, error) {
+	switch include := values["include"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{include}, nil
+	case []interface{}:
+		patterns := make([]string, len(include))
+		for n, pattern := range include {
+			str, ok := pattern.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected array of strings")
+			}
+			patterns[n] = str
+		}
+		return patterns, nil
+	}
+	return nil, fmt.Errorf("expected string or array of strings")
+}
+
+// readConfigDir reads the configuration files in dir. It also returns the
+// paths of the files read, up to and including a broken one.
+func readConfigDir(dir string) ([]configFile, []string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && isConfigFile(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no configuration files in %s", dir)
+	}
+	sort.Strings(names)
+
+	files := make([]configFile, 0, len(names))
+	read := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		read = append(read, path)
+		values, err := readConfig(path)
+		if err != nil {
+			return nil, read, err
+		}
+		files = append(files, configFile{path: path, values: values})
+	}
+	return files, read, nil
+}
+
+func isConfigFile(name string) bool {
+	for _, ext := range config.Extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfig reads a JSON, YAML or TOML file, depending on its extension,
+// and resolves the secrets it refers to.
+func readConfig(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := config.Decode(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if errs := config.ResolveSecrets(path, values); len(errs) > 0 {
+		return nil, errs
+	}
+	return values, nil
+}
+
+// watchConfig requests a reload when one of the configuration files
+// changes, or a file is added to or removed from an included directory.
+func (em *EventManagerImpl) watchConfig(reload chan<- bool) {
+	paths := em.watchedPaths()
+	last := fingerprint(paths)
+	for {
+		select {
+		case <-em.ctx.Done():
+			return
+		case <-time.After(configPollInterval):
+		}
+		current := em.watchedPaths()
+		if strings.Join(current, "\n") != strings.Join(paths, "\n") {
+			// the configuration was reloaded and includes other files now
+			paths, last = current, fingerprint(current)
+			continue
+		}
+		if fp := fingerprint(paths); fp != last {
+			last = fp
+			logger.Debugf("Configuration %s changed", em.configPath)
+			select {
+			case reload <- true:
+			default:
+			}
+		}
+	}
+}
+
+func (em *EventManagerImpl) watchedPaths() []string {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+	return em.watch
+}
+
+func fingerprint(paths []string) string {
+	fp := ""
+	for _, path := range paths {
+		fp += fmt.Sprintf("%s@%d;", path, modTime(path).UnixNano())
+	}
+	return fp
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
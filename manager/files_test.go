@@ -0,0 +1,178 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeFiles creates the files, by path relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// relative strips dir from the paths
+func relative(dir string, paths []string) []string {
+	rel := make([]string, len(paths))
+	for n, path := range paths {
+		rel[n], _ = filepath.Rel(dir, path)
+	}
+	return rel
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"config.json": `{
+			"include": ["conf.d", "extra/*.toml"],
+			"bridges": [{"name": "mqtt1", "type": "mqtt", "host": "localhost", "port": 1883, "clientId": "events"}]
+		}`,
+		"conf.d/b.yaml":    "rules:\n  - type: regex\n    regex: ^mqtt://\n    actions:\n      - type: wait\n        seconds: 1\n",
+		"conf.d/a.json":    `{"bridges": [{"name": "mqtt2", "type": "mqtt", "host": "localhost", "port": 1884, "clientId": "events"}]}`,
+		"conf.d/notes.txt": "not a configuration file",
+		"extra/x.toml":     "shutdownGracePeriod = 3\n",
+	})
+
+	files, watch, err := loadConfig(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+	if want := []string{"config.json", "conf.d/a.json", "conf.d/b.yaml", "extra/x.toml"}; !reflect.DeepEqual(relative(dir, paths), want) {
+		t.Errorf("read %v, want %v", relative(dir, paths), want)
+	}
+	if want := []string{"config.json", "conf.d", "conf.d/a.json", "conf.d/b.yaml", "extra", "extra/x.toml"}; !reflect.DeepEqual(relative(dir, watch), want) {
+		t.Errorf("watching %v, want %v", relative(dir, watch), want)
+	}
+
+	cfg, err := new(EventManagerImpl).initialize().build(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.newBridges) != 2 || cfg.newBridges["mqtt1"] == nil || cfg.newBridges["mqtt2"] == nil {
+		t.Errorf("built bridges %v, want mqtt1 and mqtt2", cfg.newBridges)
+	}
+	if len(cfg.rules) != 1 {
+		t.Errorf("built %d rules, want 1", len(cfg.rules))
+	}
+	if cfg.gracePeriod.Seconds() != 3 {
+		t.Errorf("grace period is %s, want the one of extra/x.toml", cfg.gracePeriod)
+	}
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"b.json": `{}`,
+		"a.yml":  "rules: []\n",
+	})
+	files, watch, err := loadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].main || files[1].main {
+		t.Errorf("read %v, want two files besides each other", files)
+	}
+	if want := []string{".", "a.yml", "b.json"}; !reflect.DeepEqual(relative(dir, watch), want) {
+		t.Errorf("watching %v, want %v", relative(dir, watch), want)
+	}
+}
+
+// A broken file is watched, so that fixing it triggers a reload
+func TestLoadConfigWatchOnError(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		watch []string
+	}{
+		{map[string]string{
+			"config.json":   `{"include": "conf.d"}`,
+			"conf.d/a.json": `{"bridges": [}`,
+			"conf.d/b.json": `{}`,
+		}, []string{"config.json", "conf.d", "conf.d/a.json"}},
+		{map[string]string{
+			"config.json":      `{"include": ["rules/*.yaml"]}`,
+			"rules/good.yaml":  "rules: []\n",
+			"rules/worse.yaml": "rules: [\n",
+		}, []string{"config.json", "rules", "rules/good.yaml", "rules/worse.yaml"}},
+		{map[string]string{
+			"config.json": `{"include": "missing/extra.json"}`,
+		}, []string{"config.json", "missing"}},
+		{map[string]string{
+			"config.json": `{"include": [}`,
+		}, []string{"config.json"}},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		writeFiles(t, dir, test.files)
+		_, watch, err := loadConfig(filepath.Join(dir, "config.json"))
+		if err == nil {
+			t.Errorf("%v: no error", test.files)
+			continue
+		}
+		if !reflect.DeepEqual(relative(dir, watch), test.watch) {
+			t.Errorf("%v: watching %v, want %v", test.files, relative(dir, watch), test.watch)
+		}
+	}
+}
+
+func TestBuildConflicts(t *testing.T) {
+	mqtt := `{"name": "mqtt1", "type": "mqtt", "host": "localhost", "port": 1883, "clientId": "events"}`
+	tests := []struct {
+		files map[string]string
+		error string
+	}{
+		{map[string]string{
+			"config.json":   `{"include": "conf.d", "bridges": [` + mqtt + `]}`,
+			"conf.d/a.json": `{"bridges": [` + mqtt + `]}`,
+		}, `conf.d/a.json: bridges[0].name: duplicate bridge name "mqtt1", also defined in ` + "DIR/config.json"},
+		{map[string]string{
+			"config.json":   `{"include": "conf.d", "shutdownGracePeriod": 5}`,
+			"conf.d/a.yaml": "shutdownGracePeriod: 3\n",
+		}, "conf.d/a.yaml: shutdownGracePeriod: already defined in DIR/config.json"},
+		{map[string]string{
+			"config.json":   `{"include": "conf.d"}`,
+			"conf.d/a.json": `{"include": "more"}`,
+		}, "conf.d/a.json: include: only allowed in the main configuration file"},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		writeFiles(t, dir, test.files)
+		files, _, err := loadConfig(filepath.Join(dir, "config.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = new(EventManagerImpl).initialize().build(files)
+		want := filepath.Join(dir, strings.Replace(test.error, "DIR", dir, -1))
+		if err == nil || err.Error() != want {
+			t.Errorf("build returned %v, want %s", err, want)
+		}
+	}
+}
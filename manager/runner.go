@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
+	"github.com/satori/go.uuid"
+)
+
+// What to do when a rule matches while its actions are still running
+const (
+	ModeParallel = "parallel"
+	ModeSingle   = "single"
+	ModeRestart  = "restart"
+	ModeQueued   = "queued"
+)
+
+const defaultMaxQueued = 10
+
+// ruleRunner runs the actions of a rule, honouring the concurrency mode
+// configured for that rule.
+type ruleRunner struct {
+	em        *EventManagerImpl
+	rule      interfaces.Rule
+	mode      string
+	maxQueued int
+
+	mutex   sync.Mutex
+	running int
+	cancel  context.CancelFunc
+	pending []*interfaces.Event
+}
+
+func newRuleRunner(em *EventManagerImpl, rule interfaces.Rule, config *config.Section) *ruleRunner {
+	runner := &ruleRunner{
+		em:        em,
+		rule:      rule,
+		mode:      config.OneOf("mode", ModeParallel, ModeParallel, ModeSingle, ModeRestart, ModeQueued),
+		maxQueued: config.OptionalInt("maxQueued", defaultMaxQueued),
+	}
+	config.AtLeast("maxQueued", 0)
+	return runner
+}
+
+// trigger starts a run of the actions for event, or ignores, queues or
+// restarts depending on the mode.
+func (r *ruleRunner) trigger(event *interfaces.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.running > 0 {
+		switch r.mode {
+		case ModeSingle:
+			logger.Infof("Rule is still running, ignoring %s", event)
+			return
+		case ModeRestart:
+			logger.Infof("Rule is still running, restarting for %s", event)
+			r.cancel()
+		case ModeQueued:
+			if len(r.pending) >= r.maxQueued {
+				logger.Warnf("Rule queue is full (%d), ignoring %s", r.maxQueued, event)
+				return
+			}
+			logger.Infof("Rule is still running, queueing %s", event)
+			r.pending = append(r.pending, event)
+			return
+		}
+	}
+	r.start(event)
+}
+
+// start must be called with the mutex held
+func (r *ruleRunner) start(event *interfaces.Event) {
+	ctx, cancel := context.WithCancel(r.em.ctx)
+	r.cancel = cancel
+	r.running++
+	r.em.runs.Add(1)
+	go r.run(ctx, cancel, event)
+}
+
+func (r *ruleRunner) run(ctx context.Context, cancel context.CancelFunc, event *interfaces.Event) {
+	defer r.em.runs.Done()
+	defer r.finished(cancel)
+
+	myId := uuid.NewV4().String()
+	ctx = interfaces.WithRunID(interfaces.WithEvent(ctx, event), myId)
+	logger.Infof(" [%s] running %d actions for %s", myId, len(r.rule.GetActions()), event)
+	for _, action := range r.rule.GetActions() {
+		if ctx.Err() != nil {
+			logger.Infof(" [%s] cancelled", myId)
+			return
+		}
+		acJson, _ := json.Marshal(action)
+		logger.Infof(" [%s] running %T action %s", myId, action, acJson)
+		if err := action.Run(ctx, r.em); err != nil {
+			if ctx.Err() != nil {
+				logger.Infof(" [%s] cancelled: %s", myId, err)
+				return
+			}
+			logger.Errorf(" [%s] %T action failed: %s", myId, action, err)
+		}
+	}
+}
+
+func (r *ruleRunner) finished(cancel context.CancelFunc) {
+	cancel()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.running--
+	if r.running == 0 && len(r.pending) > 0 {
+		next := r.pending[0]
+		r.pending = r.pending[1:]
+		r.start(next)
+	}
+}
@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cpo/events/config"
+	"github.com/cpo/events/interfaces"
+)
+
+// blockingAction reports the payload of every run on started and blocks
+// until release is closed or the run is cancelled.
+type blockingAction struct {
+	started   chan string
+	release   chan struct{}
+	mutex     sync.Mutex
+	cancelled []string
+}
+
+func newBlockingAction() *blockingAction {
+	return &blockingAction{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (a *blockingAction) Run(ctx context.Context, eventManager interfaces.EventManager) error {
+	payload := interfaces.EventFromContext(ctx).Payload
+	a.started <- payload
+	select {
+	case <-a.release:
+		return nil
+	case <-ctx.Done():
+		a.mutex.Lock()
+		a.cancelled = append(a.cancelled, payload)
+		a.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (a *blockingAction) waitStarted(t *testing.T, want string) {
+	select {
+	case payload := <-a.started:
+		if payload != want {
+			t.Fatalf("started a run for %s, want %s", payload, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no run started for %s", want)
+	}
+}
+
+// remaining returns the payloads of the runs not yet taken from started
+func (a *blockingAction) remaining() []string {
+	payloads := []string{}
+	for len(a.started) > 0 {
+		payloads = append(payloads, <-a.started)
+	}
+	return payloads
+}
+
+type fakeRule struct {
+	actions []interfaces.Action
+}
+
+func (r *fakeRule) Initialize(config *config.Section)    {}
+func (r *fakeRule) Matches(event *interfaces.Event) bool { return true }
+func (r *fakeRule) GetActions() []interfaces.Action      { return r.actions }
+
+func newTestRunner(t *testing.T, values map[string]interface{}) (*EventManagerImpl, *ruleRunner, *blockingAction) {
+	errs := config.Errors{}
+	em := new(EventManagerImpl).initialize()
+	action := newBlockingAction()
+	runner := newRuleRunner(em, &fakeRule{[]interfaces.Action{action}}, config.NewSection("rules[0]", values, &errs))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return em, runner, action
+}
+
+func testEvent(payload string) *interfaces.Event {
+	return interfaces.NewEvent("hue", "hue1", "sensors/1/button", payload)
+}
+
+func TestRunnerModes(t *testing.T) {
+	tests := []struct {
+		mode string
+		// runs started by the second and third trigger while the first
+		// run is busy, and the runs started after it was released
+		starts    []string
+		later     []string
+		cancelled []string
+	}{
+		{ModeParallel, []string{"2", "3"}, []string{}, []string{}},
+		{ModeSingle, []string{}, []string{}, []string{}},
+		{ModeRestart, []string{"2", "3"}, []string{}, []string{"1", "2"}},
+		{ModeQueued, []string{}, []string{"2"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			em, runner, action := newTestRunner(t, map[string]interface{}{"mode": test.mode, "maxQueued": float64(1)})
+			runner.trigger(testEvent("1"))
+			action.waitStarted(t, "1")
+			for _, payload := range []string{"2", "3"} {
+				runner.trigger(testEvent(payload))
+				for _, start := range test.starts {
+					if start == payload {
+						action.waitStarted(t, payload)
+					}
+				}
+			}
+			close(action.release)
+			em.runs.Wait()
+
+			if later := action.remaining(); !reflect.DeepEqual(later, test.later) {
+				t.Errorf("started %v after the first run, want %v", later, test.later)
+			}
+			cancelled := append([]string{}, action.cancelled...)
+			sort.Strings(cancelled)
+			if !reflect.DeepEqual(cancelled, test.cancelled) {
+				t.Errorf("cancelled %v, want %v", cancelled, test.cancelled)
+			}
+		})
+	}
+}
+
+func TestRunnerQueuedOrder(t *testing.T) {
+	em, runner, action := newTestRunner(t, map[string]interface{}{"mode": ModeQueued})
+	runner.trigger(testEvent("1"))
+	action.waitStarted(t, "1")
+	for _, payload := range []string{"2", "3", "4"} {
+		runner.trigger(testEvent(payload))
+	}
+	close(action.release)
+	em.runs.Wait()
+	if later := action.remaining(); !reflect.DeepEqual(later, []string{"2", "3", "4"}) {
+		t.Errorf("ran %v after the first run, want the queued events in order", later)
+	}
+}
+
+func TestRunnerShutdownCancels(t *testing.T) {
+	em, runner, action := newTestRunner(t, nil)
+	runner.trigger(testEvent("1"))
+	action.waitStarted(t, "1")
+	em.cancel()
+	em.runs.Wait()
+	if !reflect.DeepEqual(action.cancelled, []string{"1"}) {
+		t.Errorf("cancelled %v, want the running run", action.cancelled)
+	}
+}
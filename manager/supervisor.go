@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"math/rand"
+	"time"
+
+	logger "github.com/Sirupsen/logrus"
+	"github.com/cpo/events/config"
+)
+
+// retryPolicy is how failed connections of bridges and the publisher are
+// retried
+type retryPolicy struct {
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	// maxRetries is the number of retries in a row, 0 retries forever
+	maxRetries int
+}
+
+func parseRetryPolicy(s *config.Section) retryPolicy {
+	p := retryPolicy{initialDelay: time.Second, maxDelay: 5 * time.Minute}
+	if s == nil {
+		return p
+	}
+	p.initialDelay = time.Duration(s.OptionalNumber("initialDelay", 1) * float64(time.Second))
+	p.maxDelay = time.Duration(s.OptionalNumber("maxDelay", 300) * float64(time.Second))
+	p.maxRetries = s.OptionalInt("maxRetries", 0)
+	s.AtLeast("initialDelay", 0.1)
+	s.AtLeast("maxRetries", 0)
+	if p.maxDelay < p.initialDelay {
+		s.Errorf("maxDelay", "must be at least initialDelay")
+	}
+	return p
+}
+
+// delay returns the time to wait before retry n (counting from 0): it
+// doubles with every retry up to maxDelay, and varies by up to 20% so
+// that bridges failing together don't retry together.
+func (p retryPolicy) delay(n int) time.Duration {
+	delay := p.initialDelay
+	for i := 0; i < n && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	jitter := (rand.Float64()*0.4 - 0.2) * float64(delay)
+	return delay + time.Duration(jitter)
+}
+
+// supervisor runs the connection of a bridge or the publisher
+type supervisor struct {
+	name    string
+	connect func() error
+	policy  retryPolicy
+	stop    chan struct{}
+}
+
+// supervise starts running connect. Connect returns nil once the bridge
+// or publisher was stopped, and an error when its connection failed,
+// after cleaning up. Then it is called again after a delay, unless
+// maxRetries failures in a row happened. A connection that lasted longer
+// than maxDelay starts counting anew.
+func (em *EventManagerImpl) supervise(name string, connect func() error, policy retryPolicy) *supervisor {
+	s := &supervisor{name: name, connect: connect, policy: policy, stop: make(chan struct{})}
+	em.connections.Add(1)
+	go func() {
+		defer em.connections.Done()
+		s.run()
+	}()
+	return s
+}
+
+func (s *supervisor) run() {
+	failures := 0
+	for {
+		started := time.Now()
+		err := s.connect()
+		if err == nil {
+			logger.Debugf("%s stopped", s.name)
+			return
+		}
+		if time.Since(started) > s.policy.maxDelay {
+			failures = 0
+		}
+		if s.policy.maxRetries > 0 && failures >= s.policy.maxRetries {
+			logger.Errorf("%s failed: %s. Giving up after %d retries", s.name, err, failures)
+			return
+		}
+		delay := s.policy.delay(failures)
+		failures++
+		logger.Warnf("%s failed: %s. Retrying in %s", s.name, err, delay.Round(time.Millisecond))
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// halt ends the retries. The bridge or publisher itself is stopped by
+// calling its Stop.
+func (s *supervisor) halt() {
+	close(s.stop)
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cpo/events/config"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{initialDelay: time.Second, maxDelay: 10 * time.Second}
+	tests := []struct {
+		retry int
+		delay time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, test := range tests {
+		min := test.delay * 8 / 10
+		max := test.delay * 12 / 10
+		for n := 0; n < 100; n++ {
+			if delay := policy.delay(test.retry); delay < min || delay > max {
+				t.Fatalf("delay of retry %d is %s, want between %s and %s", test.retry, delay, min, max)
+			}
+		}
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		values map[string]interface{}
+		policy retryPolicy
+		errors int
+	}{
+		{map[string]interface{}{}, retryPolicy{time.Second, 5 * time.Minute, 0}, 0},
+		{map[string]interface{}{"initialDelay": 0.5, "maxDelay": float64(60), "maxRetries": float64(3)},
+			retryPolicy{500 * time.Millisecond, time.Minute, 3}, 0},
+		{map[string]interface{}{"initialDelay": float64(10), "maxDelay": float64(5)},
+			retryPolicy{10 * time.Second, 5 * time.Second, 0}, 1},
+		{map[string]interface{}{"maxRetries": float64(-1)}, retryPolicy{time.Second, 5 * time.Minute, -1}, 1},
+	}
+	for _, test := range tests {
+		errs := config.Errors{}
+		policy := parseRetryPolicy(config.NewSection("retry", test.values, &errs))
+		if policy != test.policy || len(errs) != test.errors {
+			t.Errorf("%v gives %+v with errors %v, want %+v with %d errors", test.values, policy, errs, test.policy, test.errors)
+		}
+	}
+}
+
+func TestSupervisorRetries(t *testing.T) {
+	policy := retryPolicy{initialDelay: time.Millisecond, maxDelay: 10 * time.Millisecond}
+	tests := []struct {
+		maxRetries int
+		// connect fails this many times before it returns nil
+		failures int
+		calls    int
+	}{
+		{0, 0, 1},
+		{0, 5, 6},
+		{3, 2, 3},
+		{3, 10, 4},
+	}
+	for _, test := range tests {
+		calls := 0
+		s := &supervisor{name: "test", policy: policy, stop: make(chan struct{})}
+		s.policy.maxRetries = test.maxRetries
+		s.connect = func() error {
+			calls++
+			if calls <= test.failures {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		s.run()
+		if calls != test.calls {
+			t.Errorf("maxRetries %d with %d failures connected %d times, want %d", test.maxRetries, test.failures, calls, test.calls)
+		}
+	}
+}
+
+func TestSupervisorHalt(t *testing.T) {
+	policy := retryPolicy{initialDelay: time.Hour, maxDelay: time.Hour}
+	connected := make(chan struct{}, 1)
+	s := &supervisor{name: "test", policy: policy, stop: make(chan struct{})}
+	s.connect = func() error {
+		connected <- struct{}{}
+		return errors.New("connection refused")
+	}
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+	<-connected
+	s.halt()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("halt did not end the retry delay")
+	}
+}
@@ -1,11 +1,11 @@
 package publishers
 
 import (
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 )
 
-// map with factory methods for producing bridges
-var PublisherFactories = map[string]func(interfaces.EventManager, map[string]interface{}) interfaces.Publisher{
-	// HUE bridge
-	"mqtt":  NewMQTTPublisher,
+// map with factory methods for producing publishers
+var PublisherFactories = map[string]func(interfaces.EventManager, *config.Section) interfaces.Publisher{
+	"mqtt": NewMQTTPublisher,
 }
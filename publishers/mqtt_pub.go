@@ -2,38 +2,76 @@ package publishers
 
 import (
 	"encoding/json"
+	"github.com/cpo/events/config"
 	"github.com/yosssi/gmq/mqtt/client"
 	logger "github.com/Sirupsen/logrus"
-	"fmt"
+	mqttbridge "github.com/cpo/events/bridges/mqtt"
 	"github.com/cpo/events/interfaces"
-	"strings"
-	"sync"
 	"time"
 )
 
+// MQTTPublisherConfig is the configuration of the MQTT publisher. The
+// broker is configured like for the MQTT bridge.
+type MQTTPublisherConfig struct {
+	Name   string
+	Prefix string
+	QoS    byte
+	Retain bool
+	// Format is "payload" to publish the payload of events, or "json"
+	// to publish the whole event as JSON
+	Format string
+	mqttbridge.Connection
+}
+
+func ParseMQTTPublisherConfig(s *config.Section) MQTTPublisherConfig {
+	c := MQTTPublisherConfig{
+		Name:       s.String("name"),
+		Prefix:     s.OptionalString("prefix", ""),
+		QoS:        byte(s.OptionalInt("qos", 2)),
+		Retain:     s.OptionalBool("retain", false),
+		Format:     s.OneOf("format", "payload", "payload", "json"),
+		Connection: mqttbridge.ParseConnection(s),
+	}
+	if c.QoS > 2 {
+		s.Errorf("qos", "expected 0, 1 or 2")
+	}
+	return c
+}
+
+// eventMessage is an event published with "format": "json"
+type eventMessage struct {
+	ID         string                 `json:"id"`
+	URL        string                 `json:"url"`
+	Scheme     string                 `json:"scheme"`
+	Bridge     string                 `json:"bridge"`
+	Path       string                 `json:"path"`
+	Payload    string                 `json:"payload"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
 type MQTTPublisher struct {
 	id           string
-	mqttClient   *client.Client
-	config       map[string]interface{}
-	wg           sync.WaitGroup
+	client       *mqttbridge.Client
+	config       MQTTPublisherConfig
+	stop         chan struct{}
 	prefix       string
-	ready        bool
 	eventManager interfaces.EventManager
 }
 
-func NewMQTTPublisher(manager interfaces.EventManager, config map[string]interface{}) interfaces.Publisher {
+func NewMQTTPublisher(manager interfaces.EventManager, config *config.Section) interfaces.Publisher {
 	p := new(MQTTPublisher).Initialize(manager, config)
 	return p
 }
 
-func (mqp *MQTTPublisher) Initialize(eventManager interfaces.EventManager, config map[string]interface{}) interfaces.Publisher {
-	cfgStr, _ := json.Marshal(config)
-	mqp.id = config["name"].(string)
-	mqp.config = config
-	mqp.prefix = config["prefix"].(string)
-	mqp.ready = false
+func (mqp *MQTTPublisher) Initialize(eventManager interfaces.EventManager, config *config.Section) interfaces.Publisher {
+	mqp.config = ParseMQTTPublisherConfig(config)
+	mqp.id = mqp.config.Name
+	mqp.prefix = mqp.config.Prefix
 	mqp.eventManager = eventManager
-	logger.Debugf("Initialize MQTT publisher %s with %s", mqp.GetID(), cfgStr)
+	mqp.client = mqttbridge.NewClient("MQTT publisher "+mqp.id, mqp.id, mqp.config.Connection, eventManager)
+	mqp.stop = make(chan struct{})
+	logger.Debugf("Initialize MQTT publisher %s with %s", mqp.GetID(), config.JSON())
 	return mqp
 }
 
@@ -41,69 +79,41 @@ func (mqp *MQTTPublisher) GetID() string {
 	return mqp.id
 }
 
-func (mqp *MQTTPublisher) Connect() {
-	logger.Infof("Connecting MQTT publisher %s", mqp.id)
-	mqp.mqttClient = client.New(&client.Options{})
-	defer mqp.mqttClient.Terminate()
-	defer mqp.connectRecovery()
-
-	logger.Infof("MQTT connecting client %s to %s://%s:%.0f",
-		mqp.config["clientId"].(string),
-		mqp.config["proto"].(string),
-		mqp.config["host"].(string),
-		mqp.config["port"].(float64))
-
-	err := mqp.mqttClient.Connect(&client.ConnectOptions{
-		Network:  mqp.config["proto"].(string),
-		Address:  mqp.config["host"].(string) + ":" + fmt.Sprintf("%.0f", mqp.config["port"].(float64)),
-		ClientID: []byte(mqp.config["clientId"].(string)),
-	})
-	if err != nil {
-		logger.Panic(err)
-	}
-
-	mqp.ready = true
-
-	logger.Debugf("MQTT publisher %s connected.", mqp.id)
-
-	mqp.wg = sync.WaitGroup{}
-	mqp.wg.Add(1)
-	mqp.wg.Wait()
-
-	logger.Debugf("Stop MQTT publisher %s", mqp.id)
-
-	mqp.ready = false
-	if err := mqp.mqttClient.Disconnect(); err != nil {
-		logger.Panic(err)
-	}
-}
-
-func (mqp *MQTTPublisher) connectRecovery() {
-	if r := recover(); r != nil {
-		logger.Debugf("Recovering connection for MQTT publisher %s", mqp.id)
-		time.Sleep(3 * time.Second)
-		mqp.Connect()
-	}
+// Connect connects to the broker. It returns nil once the publisher is
+// stopped, and an error when connecting failed or the connection was
+// lost.
+func (mqp *MQTTPublisher) Connect() error {
+	return mqp.client.Run(mqp.stop, nil, nil)
 }
 
 func (mqp *MQTTPublisher) Stop() {
-	mqp.wg.Done()
+	close(mqp.stop)
 }
 
-func (mqp *MQTTPublisher) Publish(url string) {
-	url2 := url[strings.Index(url, "://")+3:]
-	logger.Debugf("Publishing MQTT publisher %s: %s", mqp.id, url2)
-	lastIndex := strings.LastIndex(url2, "#")
-	var message string = ""
-	if lastIndex >= 0 {
-		message = url2[lastIndex:]
-		url2 = url2[:lastIndex]
-	}
-	if mqp.ready {
-		logger.Debugf(" topic: %s message: %s", url2, message)
-		mqp.mqttClient.Publish(&client.PublishOptions{TopicName: []byte(mqp.prefix + url2), Message: []byte(message), QoS:2, Retain:false})
-	} else {
-		logger.Warnf("Cannot publish %s", url)
+func (mqp *MQTTPublisher) message(event *interfaces.Event) []byte {
+	if mqp.config.Format != "json" {
+		return []byte(event.Payload)
 	}
+	message, _ := json.Marshal(eventMessage{
+		ID:         event.ID,
+		URL:        event.URL(),
+		Scheme:     event.Scheme,
+		Bridge:     event.Bridge,
+		Path:       event.Path,
+		Payload:    event.Payload,
+		Timestamp:  event.Timestamp,
+		Attributes: event.Attributes,
+	})
+	return message
+}
 
+func (mqp *MQTTPublisher) Publish(event *interfaces.Event) {
+	topic := mqp.prefix + event.Bridge + "/" + event.Path
+	logger.Debugf("Publishing MQTT publisher %s: %s", mqp.id, topic)
+	message := mqp.message(event)
+	logger.Debugf(" topic: %s message: %s", topic, message)
+	err := mqp.client.Publish(&client.PublishOptions{TopicName: []byte(topic), Message: message, QoS: mqp.config.QoS, Retain: mqp.config.Retain})
+	if err != nil {
+		logger.Warnf("Cannot publish %s: %s", event, err)
+	}
 }
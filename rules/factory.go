@@ -1,18 +1,17 @@
 package rules
 
 import (
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 )
 
-// map with factory methods for producing bridges
-var RuleFactories = map[string]func(map[string]interface{}) interfaces.Rule{
-	// HUE bridge
+// map with factory methods for producing rules
+var RuleFactories = map[string]func(*config.Section) interfaces.Rule{
 	"regex": NewRegExRule,
 }
 
-func NewRegExRule(config map[string]interface{}) interfaces.Rule {
+func NewRegExRule(config *config.Section) interfaces.Rule {
 	re := RegExRule{}
 	re.Initialize(config)
 	return &re
 }
-
@@ -2,23 +2,51 @@ package rules
 
 import (
 	"github.com/cpo/events/actions"
+	"github.com/cpo/events/config"
 	"github.com/cpo/events/interfaces"
 	"regexp"
 )
 
 type RegExRule struct {
-	regex string
-	actions []interfaces.Action
+	// regex is matched against the URL form of events
+	regex *regexp.Regexp
+	// attributes are regular expressions the attributes of the event
+	// must match too, by path, e.g. ENERGY.Power
+	attributes map[string]*regexp.Regexp
+	actions    []interfaces.Action
 }
 
-func (re *RegExRule) Initialize(config map[string]interface{}) {
-	re.regex = config["regex"].(string)
-	re.actions = actions.ParseActions(config["actions"].([]interface{}))
+func (re *RegExRule) Initialize(config *config.Section) {
+	regex, err := regexp.Compile(config.String("regex"))
+	if err != nil {
+		config.Errorf("regex", "%s", err)
+	}
+	re.regex = regex
+	if attributes := config.OptionalSection("attributes"); attributes != nil {
+		re.attributes = make(map[string]*regexp.Regexp)
+		for path := range attributes.Raw() {
+			regex, err := regexp.Compile(attributes.String(path))
+			if err != nil {
+				attributes.Errorf(path, "%s", err)
+				continue
+			}
+			re.attributes[path] = regex
+		}
+	}
+	re.actions = actions.ParseActions(config.List("actions"))
 }
 
-func (re *RegExRule) Matches(url string) bool {
-	m, _ := regexp.Match(re.regex, []byte(url))
-	return m
+func (re *RegExRule) Matches(event *interfaces.Event) bool {
+	if re.regex == nil || !re.regex.MatchString(event.URL()) {
+		return false
+	}
+	for path, regex := range re.attributes {
+		value, found := event.Attribute(path)
+		if !found || !regex.MatchString(interfaces.FormatValue(value)) {
+			return false
+		}
+	}
+	return true
 }
 
 func (re *RegExRule) GetActions() []interfaces.Action {